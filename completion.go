@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// completionCommands are the commands offered by shell completion: quay's own commands
+// followed by the Docker Compose commands used most often
+var completionCommands = []string{
+	"completion", "config", "diff", "graph", "groups", "lock", "ports", "services",
+	"build", "down", "exec", "logs", "ps", "pull", "restart", "rm", "run", "start", "stop", "up",
+}
+
+// completionOptions are the command options offered by shell completion
+var completionOptions = []string{
+	"--check-ports", "--command", "--dry-run", "--entrypoint", "--env", "-e", "--exclude",
+	"--exclude-deps-mode", "--exclude-file", "--exclude-label", "--exclude-re", "--force", "--format",
+	"--group", "--image", "--include", "--include-deps", "--include-dependents", "--include-file",
+	"--include-label", "--include-re", "--keep-build", "--keep-required", "--live",
+	"--no-keep-required", "--no-ports", "--no-remove-orphans", "--override", "--port", "--port-offset",
+	"--profile", "--prune-unused", "--registry", "--registry-replace", "--scale", "--select",
+	"--show-command", "--strict", "--unset-env", "--volume", "--with-deps",
+}
+
+// serviceNameOptions are the command options that take a service name, completed dynamically
+var serviceNameOptions = []string{"--include", "--exclude", "--no-ports"}
+
+// completeServicesCommand is the hidden command completion scripts use to list service names
+const completeServicesCommand = "__complete-services"
+
+// executeCompletionCommand handles "quay completion SHELL" by printing a completion script
+// for bash, zsh or fish
+func executeCompletionCommand(options []string) error {
+	if len(options) != 1 {
+		return fmt.Errorf("usage: quay completion bash|zsh|fish")
+	}
+
+	commands := strings.Join(completionCommands, " ")
+	flags := strings.Join(completionOptions, " ")
+	serviceOptions := strings.Join(serviceNameOptions, "|")
+
+	switch options[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, serviceOptions, completeServicesCommand, flags, commands)
+	case "zsh":
+		fmt.Printf(zshCompletion, serviceOptions, completeServicesCommand, flags, commands)
+	case "fish":
+		fmt.Printf(fishCompletion, commands)
+		for _, option := range completionOptions {
+			fmt.Printf("complete -c quay %s\n", fishOption(option))
+		}
+		for _, option := range serviceNameOptions {
+			fmt.Printf("complete -c quay %s -x -a '(quay %s 2>/dev/null)'\n", fishOption(option), completeServicesCommand)
+		}
+	default:
+		return fmt.Errorf("unsupported shell: %s, expected bash, zsh or fish", options[0])
+	}
+
+	return nil
+}
+
+// fishOption describes an option to fish's complete builtin, which takes long options with -l
+// and single-letter ones with -s
+func fishOption(option string) string {
+	if name, long := strings.CutPrefix(option, "--"); long {
+		return "-l " + name
+	}
+	return "-s " + strings.TrimPrefix(option, "-")
+}
+
+// executeCompleteServicesCommand prints the names of all services for shell completion,
+// including services of inactive profiles since including them enables their profile.
+// Failures print nothing, so completion never shows errors
+func executeCompleteServicesCommand(composePaths []string, cmdArgs commandArgs) error {
+	project, err := loadFilteredProject(composePaths, commandArgs{profiles: cmdArgs.profiles, envFiles: cmdArgs.envFiles})
+	if err != nil {
+		return nil
+	}
+
+	names := project.ServiceNames()
+	for name := range project.DisabledServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+const bashCompletion = `_quay() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        %s)
+            COMPREPLY=($(compgen -W "$(quay %s 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    elif [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    fi
+}
+complete -o default -F _quay quay
+`
+
+const zshCompletion = `#compdef quay
+_quay() {
+    case "${words[CURRENT-1]}" in
+        %s)
+            compadd -- ${(f)"$(quay %s 2>/dev/null)"}
+            return
+            ;;
+    esac
+    if [[ "$PREFIX" == -* ]]; then
+        compadd -- %s
+    elif (( CURRENT == 2 )); then
+        compadd -- %s
+    else
+        _files
+    fi
+}
+compdef _quay quay
+`
+
+const fishCompletion = `complete -c quay -f -n '__fish_use_subcommand' -a '%s'
+`
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFishCompletion(t *testing.T) {
+	stdout, _, err := captureOutput(t, func() error {
+		return executeCompletionCommand([]string{"fish"})
+	})
+	if err != nil {
+		t.Fatalf("executeCompletionCommand: %v", err)
+	}
+
+	lines := strings.Split(stdout, "\n")
+	for _, want := range []string{
+		"complete -c quay -s e",
+		"complete -c quay -l env",
+		"complete -c quay -l include -x -a '(quay __complete-services 2>/dev/null)'",
+	} {
+		if !contains(lines, want) {
+			t.Errorf("fish completion lacks %q:\n%s", want, stdout)
+		}
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "-l -") {
+			t.Errorf("invalid fish completion line %q", line)
+		}
+	}
+}
+
+func TestCompletionShells(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh"} {
+		stdout, _, err := captureOutput(t, func() error {
+			return executeCompletionCommand([]string{shell})
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		if !strings.Contains(stdout, " -e ") || !strings.Contains(stdout, "--include|--exclude|--no-ports)") {
+			t.Errorf("%s completion lacks the options:\n%s", shell, stdout)
+		}
+	}
+
+	if err := executeCompletionCommand([]string{"powershell"}); err == nil {
+		t.Errorf("want an error for an unsupported shell")
+	}
+}
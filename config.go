@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/yarlson/quay/pkg/quay"
+)
+
+// executeConfigCommand handles "quay config": the project is loaded, filtered and overridden
+// exactly as for any other command, validated, and printed instead of being handed to
+// docker-compose. --services prints only the service names, --quiet only validates and
+// -o/--output writes to a file, like the global -o option
+func executeConfigCommand(composePaths []string, cmdArgs commandArgs) error {
+	servicesOnly, quiet := false, false
+	output := cmdArgs.output
+	options := cmdArgs.cmdOptions
+	for i := 0; i < len(options); i++ {
+		switch {
+		case options[i] == "--services":
+			servicesOnly = true
+		case options[i] == "--quiet" || options[i] == "-q":
+			quiet = true
+		case (options[i] == "-o" || options[i] == "--output") && i+1 < len(options):
+			output = options[i+1]
+			i++ // Skip the next option as it's the output path
+		default:
+			return fmt.Errorf("unsupported config option: %s", options[i])
+		}
+	}
+
+	project, err := loadFilteredProject(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	yamlData, err := marshalProject(project)
+	if err != nil {
+		return err
+	}
+
+	if err := validateRenderedProject(project, yamlData); err != nil {
+		return err
+	}
+
+	if quiet {
+		return nil
+	}
+
+	if servicesOnly {
+		for _, name := range project.ServiceNames() {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	rendered, err := renderProject(project, cmdArgs.format)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = "-"
+	}
+	return writeProjectFile(output, rendered)
+}
+
+// executeServicesCommand handles "quay services": it prints every service of the project,
+// including those inactive under the current profiles, with its image, published ports and
+// profiles, and whether the filter options select it. --quiet prints only the names of the
+// selected services and --format json prints the table as JSON
+func executeServicesCommand(composePaths []string, cmdArgs commandArgs) error {
+	quiet := false
+	for _, option := range cmdArgs.cmdOptions {
+		switch option {
+		case "--quiet", "-q":
+			quiet = true
+		default:
+			return fmt.Errorf("unsupported services option: %s", option)
+		}
+	}
+	if cmdArgs.format == formatYAML {
+		return fmt.Errorf("unsupported services format: %s", cmdArgs.format)
+	}
+
+	project, filteredProject, err := loadProjects(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	if quiet {
+		for _, name := range filteredProject.ServiceNames() {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	listing := listServices(project, filteredProject)
+
+	if cmdArgs.format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listing)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERVICE\tIMAGE\tPORTS\tPROFILES\tSELECTED")
+	for _, entry := range listing {
+		selected := "✗"
+		if entry.Selected {
+			selected = "✓"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", entry.Name, entry.Image,
+			strings.Join(entry.Ports, ","), strings.Join(entry.Profiles, ","), selected)
+	}
+	return writer.Flush()
+}
+
+// serviceListing describes a service for quay services
+type serviceListing struct {
+	Name     string   `json:"name"`
+	Image    string   `json:"image,omitempty"`
+	Ports    []string `json:"ports,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+	Selected bool     `json:"selected"`
+}
+
+// listServices describes all services of the loaded project, active or not, sorted by name.
+// Selected services are described as they are in the filtered project, with overrides applied
+func listServices(project, filteredProject *types.Project) []serviceListing {
+	services := make(map[string]types.ServiceConfig)
+	for name, service := range project.DisabledServices {
+		services[name] = service
+	}
+	for name, service := range project.Services {
+		services[name] = service
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	listing := make([]serviceListing, 0, len(names))
+	for _, name := range names {
+		service, selected := filteredProject.Services[name]
+		if !selected {
+			service = services[name]
+		}
+
+		var ports []string
+		for _, port := range service.Ports {
+			if port.Published == "" {
+				continue
+			}
+			published := port.Published
+			if port.HostIP != "" {
+				published = net.JoinHostPort(port.HostIP, port.Published)
+			}
+			ports = append(ports, fmt.Sprintf("%s->%d/%s", published, port.Target, quay.PortProtocol(port)))
+		}
+
+		listing = append(listing, serviceListing{
+			Name:     name,
+			Image:    service.Image,
+			Ports:    ports,
+			Profiles: service.Profiles,
+			Selected: selected,
+		})
+	}
+	return listing
+}
+
+// validateRenderedProject loads the rendered compose file back with compose-go, so filtering
+// mistakes such as references to removed services surface as schema or consistency errors
+func validateRenderedProject(project *types.Project, yamlData []byte) error {
+	if _, err := loadRenderedProject(project, yamlData); err != nil {
+		return fmt.Errorf("validating filtered project: %w", err)
+	}
+	return nil
+}
+
+// loadRenderedProject loads a rendered compose file with the name, directory, environment
+// and active profiles of the project it was rendered from. Further compose files are merged
+// on top of it in order
+func loadRenderedProject(project *types.Project, yamlData []byte, overrides ...types.ConfigFile) (*types.Project, error) {
+	configDetails := types.ConfigDetails{
+		WorkingDir:  project.WorkingDir,
+		ConfigFiles: append([]types.ConfigFile{{Filename: "quay-config.yml", Content: yamlData}}, overrides...),
+		Environment: project.Environment,
+	}
+
+	return loader.LoadWithContext(context.Background(), configDetails, func(options *loader.Options) {
+		options.SetProjectName(project.Name, true)
+		options.Profiles = project.Profiles
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenDir holds the fixture project and the expected output of commands run on it
+const goldenDir = "testdata/golden"
+
+// goldenComposePaths returns the compose file of the fixture project, making sure the
+// environment doesn't rename it
+func goldenComposePaths(t *testing.T) []string {
+	t.Helper()
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	composePath, err := filepath.Abs(filepath.Join(goldenDir, "compose.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{composePath}
+}
+
+// checkGolden compares output with the golden file of the given name, after replacing the
+// absolute path of the fixture directory, which differs between checkouts, with $GOLDEN_DIR.
+// With -update the golden file is rewritten instead
+func checkGolden(t *testing.T, name, output string) {
+	t.Helper()
+	dir, err := filepath.Abs(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []byte(strings.ReplaceAll(output, dir, "$GOLDEN_DIR"))
+
+	goldenPath := filepath.Join(goldenDir, name)
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("reading golden file (run go test -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s:\n--- got\n%s\n--- want\n%s", goldenPath, got, want)
+	}
+}
+
+// captureOutput runs fn and returns what it wrote to standard output and standard error,
+// diagnostics included
+func captureOutput(t *testing.T, fn func() error) (string, string, error) {
+	t.Helper()
+
+	capture := func(target **os.File) (func() string, func()) {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *target
+		*target = writer
+
+		var buf bytes.Buffer
+		copied := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(&buf, reader)
+			close(copied)
+		}()
+		restore := func() { *target = original }
+		return func() string {
+			writer.Close()
+			<-copied
+			reader.Close()
+			return buf.String()
+		}, restore
+	}
+
+	stdout, restoreStdout := capture(&os.Stdout)
+	defer restoreStdout()
+	stderr, restoreStderr := capture(&os.Stderr)
+	defer restoreStderr()
+
+	originalDiagnostics := diagnostics
+	diagnostics = os.Stderr
+	defer func() { diagnostics = originalDiagnostics }()
+
+	err := fn()
+	return stdout(), stderr(), err
+}
+
+func TestConfigGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		golden string
+	}{
+		{
+			name:   "yaml",
+			args:   []string{"--include", "web,api", "--port", "web:8081:80", "--env", "api:LOG_LEVEL=debug"},
+			golden: "config.yaml.golden",
+		},
+		{
+			name:   "json",
+			args:   []string{"--include", "web,api", "--port", "web:8081:80", "--env", "api:LOG_LEVEL=debug", "--format", "json"},
+			golden: "config.json.golden",
+		},
+		{
+			name:   "exclude",
+			args:   []string{"--exclude", "web", "--command", "api=", "--image", "db=postgres:17"},
+			golden: "config-exclude.yaml.golden",
+		},
+		{
+			name:   "services",
+			args:   []string{"--include", "web", "--with-deps", "--services"},
+			golden: "config-services.golden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs("config", tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+
+			stdout, _, err := captureOutput(t, func() error {
+				return executeConfigCommand(goldenComposePaths(t), cmdArgs)
+			})
+			if err != nil {
+				t.Fatalf("executeConfigCommand: %v", err)
+			}
+			checkGolden(t, tt.golden, stdout)
+		})
+	}
+}
+
+func TestRenderProjectGolden(t *testing.T) {
+	cmdArgs, err := parseRemainingArgs("config", []string{"--include", "db"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	project, err := loadFilteredProject(goldenComposePaths(t), cmdArgs)
+	if err != nil {
+		t.Fatalf("loadFilteredProject: %v", err)
+	}
+
+	for format, golden := range map[string]string{formatYAML: "render-db.yaml.golden", formatJSON: "render-db.json.golden"} {
+		rendered, err := renderProject(project, format)
+		if err != nil {
+			t.Fatalf("renderProject(%s): %v", format, err)
+		}
+		checkGolden(t, golden, string(rendered))
+	}
+}
+
+func TestPruneUnusedResourcesGolden(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	cmdArgs, err := parseRemainingArgs("config", []string{"--include", "web", "--prune-unused"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	project, err := loadFilteredProject([]string{filepath.Join(goldenDir, "networks.yaml")}, cmdArgs)
+	if err != nil {
+		t.Fatalf("loadFilteredProject: %v", err)
+	}
+
+	rendered, err := renderProject(project, formatYAML)
+	if err != nil {
+		t.Fatalf("renderProject: %v", err)
+	}
+	checkGolden(t, "prune-networks.yaml.golden", string(rendered))
+}
+
+func TestServicesGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		golden string
+	}{
+		{
+			name:   "table",
+			args:   []string{"--include", "web,api", "--port", "web:8081:80"},
+			golden: "services.golden",
+		},
+		{
+			name:   "json",
+			args:   []string{"--include", "web,api", "--port", "web:8081:80", "--format", "json"},
+			golden: "services.json.golden",
+		},
+		{
+			name:   "quiet",
+			args:   []string{"--exclude", "web", "-q"},
+			golden: "services-quiet.golden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs("services", tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+
+			stdout, _, err := captureOutput(t, func() error {
+				return executeServicesCommand(goldenComposePaths(t), cmdArgs)
+			})
+			if err != nil {
+				t.Fatalf("executeServicesCommand: %v", err)
+			}
+			checkGolden(t, tt.golden, stdout)
+		})
+	}
+}
+
+func TestListServicesWithProfiles(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	t.Setenv("COMPOSE_PROFILES", "")
+	cmdArgs, err := parseRemainingArgs("services", []string{"--include", "web,mailhog"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+
+	var listing []serviceListing
+	_, _, err = captureOutput(t, func() error {
+		project, filteredProject, err := loadProjects([]string{filepath.Join("testdata", "profiles", "compose.yaml")}, cmdArgs)
+		if err == nil {
+			listing = listServices(project, filteredProject)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("loadProjects: %v", err)
+	}
+
+	var selected, inactive []string
+	for _, entry := range listing {
+		if entry.Selected {
+			selected = append(selected, entry.Name)
+		} else if len(entry.Profiles) > 0 {
+			inactive = append(inactive, entry.Name)
+		}
+	}
+	if want := []string{"mailhog", "web"}; !reflect.DeepEqual(selected, want) {
+		t.Errorf("selected = %q, want %q", selected, want)
+	}
+	if want := []string{"grafana", "pgadmin"}; !reflect.DeepEqual(inactive, want) {
+		t.Errorf("inactive = %q, want %q", inactive, want)
+	}
+}
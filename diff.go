@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change by quay diff
+const diffContextLines = 3
+
+// ANSI colors for quay diff on a terminal
+const (
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+	colorReset = "\033[0m"
+)
+
+// diffOp is a line of a line-based diff: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// executeDiffCommand handles "quay diff": it renders the project as loaded and the project
+// after filtering and overrides the same way quay config does, and prints a unified diff of
+// the two, colorized on a terminal. It fails with exit code 1 when they differ, so it can
+// gate CI
+func executeDiffCommand(composePaths []string, cmdArgs commandArgs) error {
+	if len(cmdArgs.cmdOptions) > 0 {
+		return fmt.Errorf("unsupported diff option: %s", cmdArgs.cmdOptions[0])
+	}
+
+	project, filteredProject, err := loadProjects(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	original, err := marshalProject(project)
+	if err != nil {
+		return err
+	}
+	filtered, err := marshalProject(filteredProject)
+	if err != nil {
+		return err
+	}
+
+	lines := unifiedDiff("original", "filtered", splitLines(string(original)), splitLines(string(filtered)), diffContextLines)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	colorize := false
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		colorize = true
+	}
+
+	for _, line := range lines {
+		if colorize {
+			line = colorizeDiffLine(line)
+		}
+		fmt.Println(line)
+	}
+	return &exitCodeError{code: 1}
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// colorizeDiffLine wraps a line of a unified diff in the color of its kind
+func colorizeDiffLine(line string) string {
+	switch {
+	case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+		return line
+	case strings.HasPrefix(line, "@@"):
+		return colorCyan + line + colorReset
+	case strings.HasPrefix(line, "-"):
+		return colorRed + line + colorReset
+	case strings.HasPrefix(line, "+"):
+		return colorGreen + line + colorReset
+	}
+	return line
+}
+
+// unifiedDiff returns the lines of a unified diff turning a into b, with the given number of
+// context lines around changes. It returns nothing when a and b are equal
+func unifiedDiff(fromName, toName string, a, b []string, context int) []string {
+	ops := diffLines(a, b)
+
+	// Line numbers in a and b before each operation, for the hunk headers
+	aPos := make([]int, len(ops)+1)
+	bPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.kind != '+' {
+			aPos[i+1]++
+		}
+		if op.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	var lines []string
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Grow the hunk over changes separated by at most twice the context
+		start := max(i-context, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, len(ops))
+				break
+			}
+			end = run
+		}
+
+		if len(lines) == 0 {
+			lines = append(lines, "--- "+fromName, "+++ "+toName)
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(aPos[start], aPos[end]-aPos[start]), hunkRange(bPos[start], bPos[end]-bPos[start])))
+		for _, op := range ops[start:end] {
+			lines = append(lines, string(op.kind)+op.line)
+		}
+		i = end
+	}
+	return lines
+}
+
+// hunkRange formats the line range of a hunk header, where an empty range names the line
+// before it
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// diffLines computes a shortest edit script turning a into b with Myers' algorithm
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace keeps v as it was before each round, to walk the edits back afterwards
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		found := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+
+	var reversed []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffOp{'+', b[y-1]})
+				y--
+			} else {
+				reversed = append(reversed, diffOp{'-', a[x-1]})
+				x--
+			}
+		}
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(reversed)-1-i] = op
+	}
+	return ops
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// numberedLines returns the lines "1" to "n", with the given lines replaced
+func numberedLines(n int, replace map[int]string) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = strconv.Itoa(i + 1)
+		if line, ok := replace[i+1]; ok {
+			lines[i] = line
+		}
+	}
+	return lines
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{
+			name: "equal",
+			a:    []string{"a", "b"},
+			b:    []string{"a", "b"},
+			want: nil,
+		},
+		{
+			name: "changed line",
+			a:    []string{"a", "b", "c", "d", "e"},
+			b:    []string{"a", "b", "X", "d", "e"},
+			want: []string{"--- a", "+++ b", "@@ -2,3 +2,3 @@", " b", "-c", "+X", " d"},
+		},
+		{
+			name: "added to empty",
+			a:    nil,
+			b:    []string{"a", "b"},
+			want: []string{"--- a", "+++ b", "@@ -0,0 +1,2 @@", "+a", "+b"},
+		},
+		{
+			name: "removed everything",
+			a:    []string{"a", "b"},
+			b:    nil,
+			want: []string{"--- a", "+++ b", "@@ -1,2 +0,0 @@", "-a", "-b"},
+		},
+		{
+			name: "distant changes",
+			a:    numberedLines(12, nil),
+			b:    numberedLines(12, map[int]string{2: "two", 11: "eleven"}),
+			want: []string{
+				"--- a", "+++ b",
+				"@@ -1,3 +1,3 @@", " 1", "-2", "+two", " 3",
+				"@@ -10,3 +10,3 @@", " 10", "-11", "+eleven", " 12",
+			},
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    numberedLines(8, nil),
+			b:    numberedLines(8, map[int]string{2: "two", 5: "five"}),
+			want: []string{"--- a", "+++ b", "@@ -1,6 +1,6 @@", " 1", "-2", "+two", " 3", " 4", "-5", "+five", " 6"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", tt.a, tt.b, 1); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unifiedDiff =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
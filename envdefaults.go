@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/yarlson/quay/pkg/quay"
+)
+
+// mergeEnvDefaults fills in options that were not given on the command line from the QUAY_*
+// environment variables, read through getenv, and returns the compose files to use. Options on
+// the command line take precedence: QUAY_INCLUDE and QUAY_EXCLUDE only apply when services are
+// not selected on the command line at all, --group included, QUAY_PROFILES and QUAY_COMPOSE_FILE
+// only without --profile and -f, and QUAY_PORTS mappings are applied before those given with --port
+func mergeEnvDefaults(cmdArgs *commandArgs, composeFiles []string, getenv func(string) string) ([]string, error) {
+	if strict, err := strconv.ParseBool(getenv(strictEnv)); err == nil && strict {
+		cmdArgs.strict = true
+	}
+
+	if removeOrphans, err := strconv.ParseBool(getenv(removeOrphansEnv)); err == nil && !removeOrphans {
+		cmdArgs.noRemoveOrphans = true
+	}
+
+	if !cmdArgs.IncludeMode() && !cmdArgs.ExcludeMode() && len(cmdArgs.groups) == 0 {
+		cmdArgs.IncludeServices = splitList(getenv(includeEnv))
+		cmdArgs.ExcludeServices = splitList(getenv(excludeEnv))
+	}
+
+	var portMappings []quay.PortMapping
+	for _, value := range splitList(getenv(portsEnv)) {
+		mappings, err := quay.ParsePortMapping(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port mapping '%s' in %s: %w", value, portsEnv, err)
+		}
+		portMappings = append(portMappings, mappings...)
+	}
+	cmdArgs.portMappings = append(portMappings, cmdArgs.portMappings...)
+
+	if len(cmdArgs.profiles) == 0 {
+		cmdArgs.profiles = splitList(getenv(profilesEnv))
+	}
+
+	if len(composeFiles) == 0 {
+		composeFiles = splitComposeFileList(getenv(composeFilesEnv))
+	}
+
+	return composeFiles, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yarlson/quay/pkg/quay"
+)
+
+func TestMergeEnvDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		composeFiles []string
+		env          map[string]string
+		wantInclude  []string
+		wantExclude  []string
+		wantPorts    []string
+		wantProfiles []string
+		wantFiles    []string
+		wantStrict   bool
+		wantNoOrphan bool
+		wantErr      string
+	}{
+		{
+			name:        "include from environment",
+			env:         map[string]string{includeEnv: "web,db"},
+			wantInclude: []string{"web", "db"},
+		},
+		{
+			name:        "exclude from environment",
+			env:         map[string]string{excludeEnv: "db"},
+			wantExclude: []string{"db"},
+		},
+		{
+			name:        "command line include wins over QUAY_INCLUDE",
+			args:        []string{"--include", "api"},
+			env:         map[string]string{includeEnv: "web"},
+			wantInclude: []string{"api"},
+		},
+		{
+			name:        "command line exclude suppresses QUAY_INCLUDE",
+			args:        []string{"--exclude", "api"},
+			env:         map[string]string{includeEnv: "web"},
+			wantExclude: []string{"api"},
+		},
+		{
+			name: "group suppresses QUAY_EXCLUDE",
+			args: []string{"--group", "backend"},
+			env:  map[string]string{excludeEnv: "db"},
+		},
+		{
+			name: "group suppresses QUAY_INCLUDE",
+			args: []string{"--group", "backend"},
+			env:  map[string]string{includeEnv: "web"},
+		},
+		{
+			name: "empty values are ignored",
+			env: map[string]string{
+				includeEnv: "", excludeEnv: "", portsEnv: "", profilesEnv: "", composeFilesEnv: "",
+				strictEnv: "", removeOrphansEnv: "",
+			},
+		},
+		{
+			name:        "blank list entries are dropped",
+			env:         map[string]string{includeEnv: " web, ,db ,"},
+			wantInclude: []string{"web", "db"},
+		},
+		{
+			name:      "QUAY_PORTS go before --port",
+			args:      []string{"--port", "web:9090:80"},
+			env:       map[string]string{portsEnv: "web:8080:80,db:5433:5432"},
+			wantPorts: []string{"web:8080:80", "db:5433:5432", "web:9090:80"},
+		},
+		{
+			name:    "malformed QUAY_PORTS",
+			env:     map[string]string{portsEnv: "web:80:80:80:80"},
+			wantErr: "invalid port mapping 'web:80:80:80:80' in QUAY_PORTS",
+		},
+		{
+			name:    "QUAY_PORTS with an invalid port",
+			env:     map[string]string{portsEnv: "web:70000:80"},
+			wantErr: "in QUAY_PORTS: invalid host port",
+		},
+		{
+			name:         "profiles from environment",
+			env:          map[string]string{profilesEnv: "debug,tools"},
+			wantProfiles: []string{"debug", "tools"},
+		},
+		{
+			name:         "command line profile wins over QUAY_PROFILES",
+			args:         []string{"--profile", "ci"},
+			env:          map[string]string{profilesEnv: "debug"},
+			wantProfiles: []string{"ci"},
+		},
+		{
+			name:      "compose files from environment",
+			env:       map[string]string{composeFilesEnv: "a.yml:b.yml"},
+			wantFiles: []string{"a.yml", "b.yml"},
+		},
+		{
+			name:         "-f wins over QUAY_COMPOSE_FILE",
+			composeFiles: []string{"custom.yml"},
+			env:          map[string]string{composeFilesEnv: "a.yml"},
+			wantFiles:    []string{"custom.yml"},
+		},
+		{
+			name:       "strict from environment",
+			env:        map[string]string{strictEnv: "true"},
+			wantStrict: true,
+		},
+		{
+			name: "unparsable QUAY_STRICT is ignored",
+			env:  map[string]string{strictEnv: "sometimes"},
+		},
+		{
+			name:         "orphan removal turned off from environment",
+			env:          map[string]string{removeOrphansEnv: "false"},
+			wantNoOrphan: true,
+		},
+		{
+			name: "QUAY_AUTO_REMOVE_ORPHANS=true keeps the default",
+			env:  map[string]string{removeOrphansEnv: "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs("up", tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+
+			files, err := mergeEnvDefaults(&cmdArgs, tt.composeFiles, func(key string) string { return tt.env[key] })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !equalStrings(cmdArgs.IncludeServices, tt.wantInclude) {
+				t.Errorf("include = %q, want %q", cmdArgs.IncludeServices, tt.wantInclude)
+			}
+			if !equalStrings(cmdArgs.ExcludeServices, tt.wantExclude) {
+				t.Errorf("exclude = %q, want %q", cmdArgs.ExcludeServices, tt.wantExclude)
+			}
+			if ports := portMappingStrings(cmdArgs.portMappings); !equalStrings(ports, tt.wantPorts) {
+				t.Errorf("ports = %q, want %q", ports, tt.wantPorts)
+			}
+			if !equalStrings(cmdArgs.profiles, tt.wantProfiles) {
+				t.Errorf("profiles = %q, want %q", cmdArgs.profiles, tt.wantProfiles)
+			}
+			if !equalStrings(files, tt.wantFiles) {
+				t.Errorf("compose files = %q, want %q", files, tt.wantFiles)
+			}
+			if cmdArgs.strict != tt.wantStrict {
+				t.Errorf("strict = %v, want %v", cmdArgs.strict, tt.wantStrict)
+			}
+			if cmdArgs.noRemoveOrphans != tt.wantNoOrphan {
+				t.Errorf("noRemoveOrphans = %v, want %v", cmdArgs.noRemoveOrphans, tt.wantNoOrphan)
+			}
+		})
+	}
+}
+
+// equalStrings compares string slices, treating nil and empty as equal
+func equalStrings(got, want []string) bool {
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+// portMappingStrings formats port mappings as SERVICE:HOST_PORT:CONTAINER_PORT for comparison
+func portMappingStrings(mappings []quay.PortMapping) []string {
+	var formatted []string
+	for _, mapping := range mappings {
+		formatted = append(formatted, mapping.ServiceName+":"+mapping.HostPort+":"+mapping.ContainerPort)
+	}
+	return formatted
+}
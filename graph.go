@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Values of the --format option of quay graph
+const (
+	graphFormatDot     = "dot"
+	graphFormatMermaid = "mermaid"
+	graphFormatTree    = "tree"
+)
+
+// graphEdge is an edge of the service graph, from a service to one it relies on, with the
+// compose attributes that declare it
+type graphEdge struct {
+	from, to string
+	kinds    []string
+	// cut is set when filtering keeps the service but drops the one it relies on
+	cut bool
+	// cycle is set when the edge is part of a dependency cycle
+	cycle bool
+}
+
+// serviceGraph is the dependency graph of a project's services
+type serviceGraph struct {
+	nodes []string
+	edges []graphEdge
+	// selected holds the services the filter options select, nil when nothing is filtered
+	selected map[string]bool
+}
+
+// executeGraphCommand handles "quay graph": it prints the graph of depends_on, links and
+// network_mode: service: references between the services of the project, as Graphviz DOT
+// (the default), a Mermaid flowchart or an ASCII tree. When services are filtered, selected
+// services are highlighted and edges filtering would cut are marked
+func executeGraphCommand(composePaths []string, cmdArgs commandArgs) error {
+	format := graphFormatDot
+	options := cmdArgs.cmdOptions
+	for i := 0; i < len(options); i++ {
+		switch {
+		case options[i] == "--format" && i+1 < len(options):
+			format = options[i+1]
+			i++ // Skip the next option as it's the format
+		default:
+			return fmt.Errorf("unsupported graph option: %s", options[i])
+		}
+	}
+
+	if format != graphFormatDot && format != graphFormatMermaid && format != graphFormatTree {
+		return fmt.Errorf("invalid graph format '%s': expected %s, %s or %s", format, graphFormatDot, graphFormatMermaid, graphFormatTree)
+	}
+
+	// Cycles are annotated in the graph rather than failing to load the project
+	cmdArgs.skipConsistencyCheck = true
+	project, filteredProject, err := loadProjects(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	var selected map[string]bool
+	if cmdArgs.IncludeMode() || cmdArgs.ExcludeMode() {
+		selected = make(map[string]bool)
+		for name := range filteredProject.Services {
+			selected[name] = true
+		}
+	}
+	graph := buildServiceGraph(project, selected)
+
+	switch format {
+	case graphFormatDot:
+		fmt.Print(graph.dot(project.Name))
+	case graphFormatMermaid:
+		fmt.Print(graph.mermaid())
+	case graphFormatTree:
+		fmt.Print(graph.tree())
+	}
+	return nil
+}
+
+// buildServiceGraph collects the depends_on, links and network_mode: service: edges between
+// the services of the project, sorted by service names
+func buildServiceGraph(project *types.Project, selected map[string]bool) serviceGraph {
+	nodes := make(map[string]bool)
+	kinds := make(map[[2]string][]string)
+	addEdge := func(from, to, kind string) {
+		nodes[to] = true
+		key := [2]string{from, to}
+		for _, existing := range kinds[key] {
+			if existing == kind {
+				return
+			}
+		}
+		kinds[key] = append(kinds[key], kind)
+	}
+
+	for name, service := range project.Services {
+		nodes[name] = true
+		for dependency := range service.DependsOn {
+			addEdge(name, dependency, "depends_on")
+		}
+		for _, link := range service.Links {
+			dependency, _, _ := strings.Cut(link, ":")
+			addEdge(name, dependency, "links")
+		}
+		if dependency, found := strings.CutPrefix(service.NetworkMode, "service:"); found {
+			addEdge(name, dependency, "network_mode")
+		}
+	}
+
+	graph := serviceGraph{selected: selected}
+	for name := range nodes {
+		graph.nodes = append(graph.nodes, name)
+	}
+	sort.Strings(graph.nodes)
+
+	adjacency := make(map[string][]string)
+	for key, edgeKinds := range kinds {
+		sort.Strings(edgeKinds)
+		graph.edges = append(graph.edges, graphEdge{from: key[0], to: key[1], kinds: edgeKinds})
+		adjacency[key[0]] = append(adjacency[key[0]], key[1])
+	}
+	sort.Slice(graph.edges, func(i, j int) bool {
+		if graph.edges[i].from != graph.edges[j].from {
+			return graph.edges[i].from < graph.edges[j].from
+		}
+		return graph.edges[i].to < graph.edges[j].to
+	})
+
+	// An edge is part of a cycle when its target leads back to its source
+	for i, edge := range graph.edges {
+		graph.edges[i].cycle = reachable(adjacency, edge.to, edge.from)
+		graph.edges[i].cut = selected != nil && selected[edge.from] && !selected[edge.to]
+	}
+	return graph
+}
+
+// reachable reports whether target can be reached from start by following the graph
+func reachable(graph map[string][]string, start, target string) bool {
+	visited := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == target {
+			return true
+		}
+		for _, next := range graph[name] {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
+
+// label describes an edge by its kinds, noting when it is part of a cycle
+func (e graphEdge) label() string {
+	label := strings.Join(e.kinds, ", ")
+	if e.cycle {
+		label += " (cycle)"
+	}
+	return label
+}
+
+// dot renders the graph in the Graphviz DOT language
+func (g serviceGraph) dot(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	b.WriteString("  node [shape=box];\n")
+	for _, node := range g.nodes {
+		if g.selected[node] {
+			fmt.Fprintf(&b, "  %q [style=filled, fillcolor=lightblue];\n", node)
+		} else {
+			fmt.Fprintf(&b, "  %q;\n", node)
+		}
+	}
+	for _, edge := range g.edges {
+		attributes := []string{fmt.Sprintf("label=%q", edge.label())}
+		if edge.cut {
+			attributes = append(attributes, "style=dashed", "color=red")
+		} else if edge.cycle {
+			attributes = append(attributes, "color=orange")
+		}
+		fmt.Fprintf(&b, "  %q -> %q [%s];\n", edge.from, edge.to, strings.Join(attributes, ", "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// mermaid renders the graph as a Mermaid flowchart. Nodes get generated IDs since service
+// names may contain characters Mermaid doesn't accept in IDs
+func (g serviceGraph) mermaid() string {
+	ids := make(map[string]string, len(g.nodes))
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	var selected []string
+	for i, node := range g.nodes {
+		ids[node] = fmt.Sprintf("s%d", i)
+		fmt.Fprintf(&b, "  %s[%q]\n", ids[node], node)
+		if g.selected[node] {
+			selected = append(selected, ids[node])
+		}
+	}
+	for _, edge := range g.edges {
+		arrow := "-->"
+		if edge.cut {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "  %s %s|%q| %s\n", ids[edge.from], arrow, edge.label(), ids[edge.to])
+	}
+	if len(selected) > 0 {
+		b.WriteString("  classDef selected fill:#add8e6\n")
+		fmt.Fprintf(&b, "  class %s selected\n", strings.Join(selected, ","))
+	}
+	return b.String()
+}
+
+// tree renders the graph as an ASCII tree rooted at the services nothing depends on. Services
+// only reachable through cycles become roots of their own, and an edge leading back into the
+// current branch is annotated instead of followed
+func (g serviceGraph) tree() string {
+	children := make(map[string][]graphEdge)
+	dependedOn := make(map[string]bool)
+	for _, edge := range g.edges {
+		children[edge.from] = append(children[edge.from], edge)
+		dependedOn[edge.to] = true
+	}
+
+	var b strings.Builder
+	printed := make(map[string]bool)
+	var walk func(name, prefix string, branch map[string]bool)
+	walk = func(name, prefix string, branch map[string]bool) {
+		printed[name] = true
+		branch[name] = true
+		for i, edge := range children[name] {
+			connector, indent := "├── ", "│   "
+			if i == len(children[name])-1 {
+				connector, indent = "└── ", "    "
+			}
+
+			line := fmt.Sprintf("%s (%s)", edge.to, strings.Join(edge.kinds, ", "))
+			line += g.nodeMarker(edge.to)
+			if edge.cut {
+				line += " [cut]"
+			}
+			if branch[edge.to] {
+				fmt.Fprintf(&b, "%s%s%s [cycle]\n", prefix, connector, line)
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s%s\n", prefix, connector, line)
+			walk(edge.to, prefix+indent, branch)
+		}
+		delete(branch, name)
+	}
+
+	root := func(name string) {
+		fmt.Fprintf(&b, "%s%s\n", name, g.nodeMarker(name))
+		walk(name, "", make(map[string]bool))
+	}
+	for _, name := range g.nodes {
+		if !dependedOn[name] {
+			root(name)
+		}
+	}
+	for _, name := range g.nodes {
+		if !printed[name] {
+			root(name)
+		}
+	}
+	return b.String()
+}
+
+// nodeMarker marks selected services in the tree when services are filtered
+func (g serviceGraph) nodeMarker(name string) string {
+	if g.selected[name] {
+		return " [selected]"
+	}
+	return ""
+}
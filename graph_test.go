@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+func TestBuildServiceGraph(t *testing.T) {
+	project := &types.Project{Services: types.Services{
+		"web": {
+			Name:      "web",
+			DependsOn: types.DependsOnConfig{"api": {Condition: types.ServiceConditionStarted}},
+			Links:     []string{"api:backend"},
+		},
+		"api": {
+			Name:      "api",
+			DependsOn: types.DependsOnConfig{"db": {Condition: types.ServiceConditionHealthy}},
+		},
+		"db":  {Name: "db", NetworkMode: "service:vpn"},
+		"vpn": {Name: "vpn", DependsOn: types.DependsOnConfig{"db": {Condition: types.ServiceConditionStarted}}},
+		// Targets outside the project still become nodes
+		"worker": {Name: "worker", Links: []string{"cache"}},
+	}}
+
+	graph := buildServiceGraph(project, map[string]bool{"web": true, "api": true})
+
+	if want := []string{"api", "cache", "db", "vpn", "web", "worker"}; !reflect.DeepEqual(graph.nodes, want) {
+		t.Errorf("nodes = %q, want %q", graph.nodes, want)
+	}
+
+	want := []graphEdge{
+		{from: "api", to: "db", kinds: []string{"depends_on"}, cut: true},
+		{from: "db", to: "vpn", kinds: []string{"network_mode"}, cycle: true},
+		{from: "vpn", to: "db", kinds: []string{"depends_on"}, cycle: true},
+		{from: "web", to: "api", kinds: []string{"depends_on", "links"}},
+		{from: "worker", to: "cache", kinds: []string{"links"}},
+	}
+	if !reflect.DeepEqual(graph.edges, want) {
+		t.Errorf("edges =\n%+v\nwant\n%+v", graph.edges, want)
+	}
+
+	// Without a selection no edge is cut
+	for _, edge := range buildServiceGraph(project, nil).edges {
+		if edge.cut {
+			t.Errorf("edge %s -> %s is cut without a selection", edge.from, edge.to)
+		}
+	}
+}
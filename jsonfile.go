@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// isJSONComposeFile reports whether a compose file is to be read as JSON: as given with
+// -file-format, otherwise when its name ends in .json
+func isJSONComposeFile(composePath, fileFormat string) bool {
+	switch fileFormat {
+	case formatJSON:
+		return true
+	case formatYAML:
+		return false
+	}
+	return strings.EqualFold(filepath.Ext(composePath), ".json")
+}
+
+// looksLikeJSON reports whether data starts like a JSON object, ignoring leading whitespace
+func looksLikeJSON(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// normalizeJSONComposeFile re-encodes a JSON compose file so the YAML parser of compose-go
+// reads it like a JSON parser would. JSON escapes YAML doesn't accept, such as the surrogate
+// pairs many generators write for characters outside the Basic Multilingual Plane, become
+// plain UTF-8, and syntax errors are reported as JSON errors
+func normalizeJSONComposeFile(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var model any
+	if err := decoder.Decode(&model); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the top-level value")
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(model); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+func TestNormalizeJSONComposeFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "surrogate pair",
+			data: `{"services":{"web":{"labels":{"icon":"\ud83d\ude80"}}}}`,
+			want: "\"icon\": \"🚀\"",
+		},
+		{
+			name: "html characters",
+			data: `{"services":{"web":{"command":"a < b && c"}}}`,
+			want: `"command": "a < b && c"`,
+		},
+		{
+			name: "numbers as written",
+			data: `{"services":{"web":{"cpus":1.50,"mem_swappiness":9007199254740993}}}`,
+			want: `"cpus": 1.50`,
+		},
+		{
+			name:    "syntax error",
+			data:    `{"services":{"web":}}`,
+			wantErr: "invalid character '}'",
+		},
+		{
+			name:    "trailing data",
+			data:    `{"services":{}} {}`,
+			wantErr: "unexpected data after the top-level value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeJSONComposeFile([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(got), tt.want) {
+				t.Errorf("normalized =\n%s\nwant it to contain %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizedJSONComposeFileLoads(t *testing.T) {
+	normalized, err := normalizeJSONComposeFile([]byte(`{
+  "name": "json",
+  "services": {"web": {"image": "nginx", "labels": {"icon": "\ud83d\ude80"}, "mem_swappiness": 9007199254740993}}
+}`))
+	if err != nil {
+		t.Fatalf("normalizeJSONComposeFile: %v", err)
+	}
+
+	project, err := loader.LoadWithContext(context.Background(), types.ConfigDetails{
+		WorkingDir:  t.TempDir(),
+		ConfigFiles: []types.ConfigFile{{Filename: "compose.json", Content: normalized}},
+		Environment: types.Mapping{},
+	})
+	if err != nil {
+		t.Fatalf("compose-go rejects the normalized file: %v\n%s", err, normalized)
+	}
+	web := project.Services["web"]
+	if got := web.Labels["icon"]; got != "🚀" {
+		t.Errorf("icon label = %q, want 🚀", got)
+	}
+	if web.MemSwappiness != 9007199254740993 {
+		t.Errorf("mem_swappiness = %d, want 9007199254740993", web.MemSwappiness)
+	}
+}
+
+func TestIsJSONComposeFile(t *testing.T) {
+	tests := []struct {
+		composePath string
+		fileFormat  string
+		want        bool
+	}{
+		{composePath: "compose.json", want: true},
+		{composePath: "compose.JSON", want: true},
+		{composePath: "compose.yaml", want: false},
+		{composePath: "compose.yaml", fileFormat: formatJSON, want: true},
+		{composePath: "compose.json", fileFormat: formatYAML, want: false},
+		{composePath: "-", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isJSONComposeFile(tt.composePath, tt.fileFormat); got != tt.want {
+			t.Errorf("isJSONComposeFile(%q, %q) = %v, want %v", tt.composePath, tt.fileFormat, got, tt.want)
+		}
+	}
+}
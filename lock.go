@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// lockFileName is the name of the override file written by "quay lock", next to the first compose file
+const lockFileName = "quay.lock.yml"
+
+// commandRunner runs an external command and returns its standard output
+type commandRunner func(name string, args ...string) ([]byte, error)
+
+// runCommand is the commandRunner used outside of tests, running the command for real.
+// The command's error output becomes part of the returned error
+func runCommand(name string, args ...string) ([]byte, error) {
+	output, err := exec.Command(name, args...).Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return output, err
+}
+
+// lockFilePath returns where the lock file of a project with the given compose files lives:
+// the project directory when one is set, otherwise the directory of the first compose file
+func lockFilePath(composePaths []string, projectDirectory string) string {
+	if projectDirectory != "" {
+		return filepath.Join(projectDirectory, lockFileName)
+	}
+	return filepath.Join(filepath.Dir(composePaths[0]), lockFileName)
+}
+
+// executeLockCommand handles "quay lock": every image of the filtered project is resolved to
+// its current digest and written to a compose override file that pins them, which "quay --locked"
+// applies later. Services that are only built have no image to pin and are skipped
+func executeLockCommand(composePaths []string, cmdArgs commandArgs) error {
+	if len(cmdArgs.cmdOptions) > 0 {
+		return fmt.Errorf("unsupported lock option: %s", cmdArgs.cmdOptions[0])
+	}
+
+	project, err := loadFilteredProject(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	services := make(map[string]map[string]string)
+	for _, name := range project.ServiceNames() {
+		image := project.Services[name].Image
+		if image == "" {
+			fmt.Fprintf(diagnostics, "Skipping service %s: it has no image to lock\n", name)
+			continue
+		}
+
+		pinned, err := resolveImageDigest(image, runCommand)
+		if err != nil {
+			return fmt.Errorf("locking image of service %s: %w", name, err)
+		}
+		services[name] = map[string]string{"image": pinned}
+	}
+
+	yamlData, err := yaml.Marshal(map[string]any{"services": services})
+	if err != nil {
+		return fmt.Errorf("marshaling lock file: %w", err)
+	}
+	yamlData = append([]byte("# Generated by quay lock, apply with quay --locked\n"), yamlData...)
+
+	output := cmdArgs.output
+	if output == "" {
+		output = lockFilePath(composePaths, cmdArgs.projectDirectory)
+	}
+	return writeProjectFile(output, yamlData)
+}
+
+// resolveImageDigest returns the image reference pinned to the digest it currently points to,
+// as repository@sha256:... References already pinned to a digest are returned unchanged.
+// The digest of a local image is used when there is one, otherwise the registry is asked
+func resolveImageDigest(image string, run commandRunner) (string, error) {
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+
+	repository := imageRepository(image)
+
+	if output, err := run("docker", "image", "inspect", "--format", "{{json .RepoDigests}}", image); err == nil {
+		var repoDigests []string
+		if err := yaml.Unmarshal(output, &repoDigests); err == nil {
+			for _, repoDigest := range repoDigests {
+				name, digest, _ := strings.Cut(repoDigest, "@")
+				if sameRepository(name, repository) {
+					return repository + "@" + digest, nil
+				}
+			}
+		}
+	}
+
+	output, err := run("docker", "buildx", "imagetools", "inspect", "--format", "{{.Manifest.Digest}}", image)
+	if err != nil {
+		return "", fmt.Errorf("resolving digest of %s: %w", image, err)
+	}
+
+	digest := strings.TrimSpace(string(output))
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("resolving digest of %s: unexpected output %q", image, digest)
+	}
+	return repository + "@" + digest, nil
+}
+
+// sameRepository reports whether two repository names refer to the same repository once
+// normalized the way Docker does, so "redis" matches "docker.io/library/redis" but
+// "someone/redis" doesn't
+func sameRepository(a, b string) bool {
+	namedA, err := reference.ParseNormalizedNamed(a)
+	if err != nil {
+		return false
+	}
+	namedB, err := reference.ParseNormalizedNamed(b)
+	if err != nil {
+		return false
+	}
+	return namedA.Name() == namedB.Name()
+}
+
+// imageRepository strips the tag from an image reference. A colon only starts a tag after
+// the last slash, since a registry host may carry a port
+func imageRepository(image string) string {
+	lastSlash := strings.LastIndex(image, "/")
+	if colon := strings.LastIndex(image, ":"); colon > lastSlash {
+		return image[:colon]
+	}
+	return image
+}
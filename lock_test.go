@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeRunner returns a commandRunner answering commands from outputs, keyed by the command
+// line joined with spaces, and failing every other command. Commands it runs are recorded
+func fakeRunner(outputs map[string]string, calls *[]string) commandRunner {
+	return func(name string, args ...string) ([]byte, error) {
+		commandLine := strings.Join(append([]string{name}, args...), " ")
+		*calls = append(*calls, commandLine)
+		if output, ok := outputs[commandLine]; ok {
+			return []byte(output), nil
+		}
+		return nil, errors.New("exit status 1: no such image")
+	}
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	const (
+		localDigest    = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		registryDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+	inspect := func(image string) string {
+		return "docker image inspect --format {{json .RepoDigests}} " + image
+	}
+	imagetools := func(image string) string {
+		return "docker buildx imagetools inspect --format {{.Manifest.Digest}} " + image
+	}
+
+	tests := []struct {
+		name      string
+		image     string
+		outputs   map[string]string
+		want      string
+		wantCalls int
+		wantErr   string
+	}{
+		{
+			name:  "already pinned",
+			image: "nginx@" + registryDigest,
+			want:  "nginx@" + registryDigest,
+		},
+		{
+			name:  "tag and digest",
+			image: "nginx:1.25@" + registryDigest,
+			want:  "nginx:1.25@" + registryDigest,
+		},
+		{
+			name:      "local image",
+			image:     "nginx:1.25",
+			outputs:   map[string]string{inspect("nginx:1.25"): `["nginx@` + localDigest + `"]`},
+			want:      "nginx@" + localDigest,
+			wantCalls: 1,
+		},
+		{
+			name:      "local image with a fully qualified repository digest",
+			image:     "redis",
+			outputs:   map[string]string{inspect("redis"): `["docker.io/library/redis@` + localDigest + `"]`},
+			want:      "redis@" + localDigest,
+			wantCalls: 1,
+		},
+		{
+			name:  "local image only known under a repository with the same suffix",
+			image: "nginx:1.25",
+			outputs: map[string]string{
+				inspect("nginx:1.25"):    `["someone/nginx@` + localDigest + `"]`,
+				imagetools("nginx:1.25"): registryDigest + "\n",
+			},
+			want:      "nginx@" + registryDigest,
+			wantCalls: 2,
+		},
+		{
+			name:  "local image among other repositories",
+			image: "ghcr.io/acme/api:2",
+			outputs: map[string]string{
+				inspect("ghcr.io/acme/api:2"): `["mirror.example.com/acme/api@` + registryDigest + `","ghcr.io/acme/api@` + localDigest + `"]`,
+			},
+			want:      "ghcr.io/acme/api@" + localDigest,
+			wantCalls: 1,
+		},
+		{
+			name:  "local image only known under another repository",
+			image: "app:1",
+			outputs: map[string]string{
+				inspect("app:1"):    `["mirror.example.com/other@` + localDigest + `"]`,
+				imagetools("app:1"): registryDigest + "\n",
+			},
+			want:      "app@" + registryDigest,
+			wantCalls: 2,
+		},
+		{
+			name:      "registry",
+			image:     "ghcr.io/acme/api:2",
+			outputs:   map[string]string{imagetools("ghcr.io/acme/api:2"): registryDigest + "\n"},
+			want:      "ghcr.io/acme/api@" + registryDigest,
+			wantCalls: 2,
+		},
+		{
+			name:      "registry with a port",
+			image:     "localhost:5000/api:2",
+			outputs:   map[string]string{imagetools("localhost:5000/api:2"): registryDigest},
+			want:      "localhost:5000/api@" + registryDigest,
+			wantCalls: 2,
+		},
+		{
+			name:      "unknown image",
+			image:     "missing:1",
+			wantErr:   "resolving digest of missing:1: exit status 1: no such image",
+			wantCalls: 2,
+		},
+		{
+			name:      "unexpected registry output",
+			image:     "app:1",
+			outputs:   map[string]string{imagetools("app:1"): "error: unauthorized\n"},
+			wantErr:   `resolving digest of app:1: unexpected output "error: unauthorized"`,
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			got, err := resolveImageDigest(tt.image, fakeRunner(tt.outputs, &calls))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Errorf("ran %q, want %d commands", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                        "nginx",
+		"nginx:1.25":                   "nginx",
+		"library/nginx:latest":         "library/nginx",
+		"ghcr.io/acme/api:2":           "ghcr.io/acme/api",
+		"localhost:5000/api":           "localhost:5000/api",
+		"localhost:5000/api:2":         "localhost:5000/api",
+		"registry:5000/team/api:dev-1": "registry:5000/team/api",
+	}
+
+	for image, want := range tests {
+		if got := imageRepository(image); got != want {
+			t.Errorf("imageRepository(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
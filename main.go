@@ -2,43 +2,150 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
-	"regexp"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/consts"
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/template"
 	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/distribution/reference"
+	"github.com/mattn/go-shellwords"
+	"github.com/yarlson/quay/pkg/quay"
 	"gopkg.in/yaml.v3"
 )
 
-// Default Docker Compose file names to check when none specified
+// defaultComposeFiles lists the Docker Compose file names to check when none specified,
+// in order of preference. The Compose Specification names come first, as in docker compose
+var defaultComposeFiles = []string{
+	"compose.yaml",
+	"compose.yml",
+	"docker-compose.yml",
+	"docker-compose.yaml",
+}
+
+// Build information, set at build time with -ldflags "-X main.version=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// diagnostics receives quay's warnings and notices. It is discarded in quiet mode, while
+// errors and the output of docker-compose itself are never suppressed
+var diagnostics io.Writer = os.Stderr
+
+// debugLog traces how quay resolves compose files, filters services and calls docker-compose.
+// It only writes to stderr in verbose mode
+var debugLog = log.New(io.Discard, "quay: ", log.LstdFlags)
+
+// Environment variables that configure quay
 const (
-	defaultComposeFile1 = "docker-compose.yml"
-	defaultComposeFile2 = "docker-compose.yaml"
+	composeBinEnv    = "QUAY_COMPOSE_BIN"
+	strictEnv        = "QUAY_STRICT"
+	includeEnv       = "QUAY_INCLUDE"
+	excludeEnv       = "QUAY_EXCLUDE"
+	portsEnv         = "QUAY_PORTS"
+	profilesEnv      = "QUAY_PROFILES"
+	composeFilesEnv  = "QUAY_COMPOSE_FILE"
+	removeOrphansEnv = "QUAY_AUTO_REMOVE_ORPHANS"
 )
 
 // main is the entry point for the application that handles Docker Compose filtering
 func main() {
 	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+		// docker-compose already reported its own failure on stderr, so only mirror its status
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		log.Printf("Error: %v", err)
+		os.Exit(quayErrorExitCode)
 	}
 }
 
+// quayErrorExitCode is the exit status for quay's own errors, such as invalid options or
+// services missing in strict mode, telling them apart from the exit status of docker-compose,
+// which quay exits with unchanged
+const quayErrorExitCode = 64
+
+// exitCodeError reports that docker-compose exited with a non-zero status
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("docker-compose exited with status %d", e.code)
+}
+
 // run processes command line arguments and executes Docker Compose commands
 // with optional service filtering
 func run() error {
-	flagSet := flag.NewFlagSet("quay", flag.ExitOnError)
-	composeFile := flagSet.String("f", "", "Path to docker-compose file")
+	flagSet := flag.NewFlagSet("quay", flag.ContinueOnError)
+	var composeFiles stringSliceFlag
+	flagSet.Var(&composeFiles, "f", "Path or http(s) URL of a docker-compose file, - for stdin (can be used multiple times)")
+	projectDirectory := flagSet.String("project-directory", "", "Project directory (defaults to the directory of the first compose file)")
+	var httpHeaders stringSliceFlag
+	flagSet.Var(&httpHeaders, "header", "HTTP header sent when fetching compose files from URLs, as \"Name: value\" (can be used multiple times)")
+	refresh := flagSet.Bool("refresh", false, "Download compose files from URLs again instead of using the cached copy")
+	httpTimeout := flagSet.Duration("http-timeout", 30*time.Second, "Timeout for fetching compose files from URLs")
+	var envFiles stringSliceFlag
+	flagSet.Var(&envFiles, "env-file", "Path to an environment file used instead of .env (can be used multiple times, later files win)")
+	strict := flagSet.Bool("strict", false, "Fail when requested services are not found (same as the --strict command option)")
+	noEnvDefaults := flagSet.Bool("no-env-defaults", false, "Ignore the defaults set with QUAY_INCLUDE, QUAY_EXCLUDE, QUAY_PORTS, QUAY_PROFILES, QUAY_COMPOSE_FILE, QUAY_STRICT and QUAY_AUTO_REMOVE_ORPHANS")
+	noParentSearch := flagSet.Bool("no-parent-search", false, "Only look for a compose file in the current directory")
+	quiet := flagSet.Bool("quiet", false, "Suppress quay's warnings and notices on stderr")
+	var verbose bool
+	flagSet.BoolVar(&verbose, "verbose", false, "Log the compose files in use, the filtering and the docker-compose command to stderr")
+	flagSet.BoolVar(&verbose, "V", false, "Verbose output (same as -verbose)")
+	var projectName string
+	flagSet.StringVar(&projectName, "p", "", "Project name (defaults to COMPOSE_PROJECT_NAME, the top-level name of the compose file, then the name of the project directory)")
+	flagSet.StringVar(&projectName, "project-name", "", "Project name (same as -p)")
+	autoRemoveOrphans := flagSet.Bool("auto-remove-orphans", true, "Add --remove-orphans to up when services are filtered (defaults to QUAY_AUTO_REMOVE_ORPHANS)")
+	dryRun := flagSet.Bool("dry-run", false, "Print what would run instead of running it (same as the --dry-run command option)")
+	composeBin := flagSet.String("compose-bin", "", "Docker Compose command to run, such as \"docker compose\" (defaults to QUAY_COMPOSE_BIN, then detection)")
+	locked := flagSet.Bool("locked", false, "Pin images to the digests recorded by quay lock")
+	output := flagSet.String("o", "", "Write the filtered compose file to this path (- for stdout) instead of running docker-compose")
+
+	fileFormat := flagSet.String("file-format", "", "Format of the compose files, json or yaml (detected from the file name or content by default)")
+	var showVersion bool
+	flagSet.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flagSet.BoolVar(&showVersion, "v", false, "Print version information and exit (same as -version)")
 
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return fmt.Errorf("parsing arguments: %w", err)
 	}
 
+	if showVersion {
+		fmt.Println(versionString())
+		return nil
+	}
+
+	if *fileFormat != "" && *fileFormat != formatJSON && *fileFormat != formatYAML {
+		return fmt.Errorf("invalid file format '%s': expected %s or %s", *fileFormat, formatJSON, formatYAML)
+	}
+
 	args := flagSet.Args()
 
 	if len(args) == 0 {
@@ -46,30 +153,351 @@ func run() error {
 		return nil
 	}
 
+	if *quiet {
+		diagnostics = io.Discard
+	}
+
+	if verbose {
+		debugLog.SetOutput(os.Stderr)
+	}
+	quay.Logger = debugLog
+
+	composeBinOverride = *composeBin
+	if composeBinOverride == "" {
+		composeBinOverride = os.Getenv(composeBinEnv)
+	}
+
 	composeCmd := args[0]
-	cmdOptions, includeServices, excludeServices, portMappings := parseRemainingArgs(args[1:])
+	cmdArgs, err := parseRemainingArgs(composeCmd, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if *strict {
+		cmdArgs.strict = true
+	}
+
+	if *dryRun {
+		cmdArgs.dryRun = true
+	}
+
+	keepOrphans := cmdArgs.noRemoveOrphans
+	if !*noEnvDefaults {
+		composeFiles, err = mergeEnvDefaults(&cmdArgs, composeFiles, os.Getenv)
+		if err != nil {
+			return err
+		}
+	}
+
+	// An explicit -auto-remove-orphans beats QUAY_AUTO_REMOVE_ORPHANS, while --no-remove-orphans
+	// on the command beats both
+	orphansFlagSet := false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "auto-remove-orphans" {
+			orphansFlagSet = true
+			cmdArgs.noRemoveOrphans = keepOrphans || !*autoRemoveOrphans
+		}
+	})
+
+	cmdArgs.output = *output
+	if projectName != "" {
+		if cmdArgs.projectName, err = normalizeProjectName(projectName); err != nil {
+			return err
+		}
+	}
+
+	for _, envFile := range envFiles {
+		if _, err := os.Stat(envFile); err != nil {
+			return fmt.Errorf("env file not found: %w", err)
+		}
+	}
+	cmdArgs.envFiles = envFiles
+
+	// docker-compose rm has a --force option of its own, so hand it on as well
+	if cmdArgs.force && composeCmd == "rm" {
+		cmdArgs.cmdOptions = append(cmdArgs.cmdOptions, "--force")
+	}
+
+	settings, err := loadProjectSettings(!*noParentSearch)
+	if err != nil {
+		return err
+	}
+
+	// The settings file has the lowest precedence: its values only apply where neither the
+	// command line nor the environment says anything
+	if !cmdArgs.IncludeMode() && !cmdArgs.ExcludeMode() && len(cmdArgs.groups) == 0 {
+		cmdArgs.IncludeServices = settings.Include
+		cmdArgs.ExcludeServices = settings.Exclude
+	}
+	orphansEnvSet := !*noEnvDefaults && os.Getenv(removeOrphansEnv) != ""
+	if settings.AutoRemoveOrphans != nil && !orphansFlagSet && !orphansEnvSet {
+		cmdArgs.noRemoveOrphans = keepOrphans || !*settings.AutoRemoveOrphans
+	}
+
+	cmdArgs.groupServices, err = settings.groupServices(cmdArgs.groups)
+	if err != nil {
+		return err
+	}
+	for _, group := range cmdArgs.groups {
+		cmdArgs.IncludeServices = append(cmdArgs.IncludeServices, cmdArgs.groupServices[group]...)
+	}
+
+	cmdArgs.defaultPortMappings = settings.portMappings
+	if len(cmdArgs.profiles) == 0 && os.Getenv("COMPOSE_PROFILES") == "" {
+		cmdArgs.profiles = settings.Profiles
+	}
+	if len(composeFiles) == 0 && len(composeFilesFromEnv()) == 0 {
+		composeFiles = stringSliceFlag(settings.File)
+	}
+
+	if cmdArgs.IncludeMode() && cmdArgs.ExcludeMode() {
+		return fmt.Errorf("cannot use both include and exclude options together")
+	}
+
+	// Completion scripts and groups are printed without a compose file
+	switch composeCmd {
+	case "completion":
+		return executeCompletionCommand(cmdArgs.cmdOptions)
+	case "groups":
+		return executeGroupsCommand(settings, cmdArgs)
+	}
 
-	if len(includeServices) > 0 && len(excludeServices) > 0 {
-		return fmt.Errorf("cannot use both --include and --exclude options together")
+	composePaths, err := findComposeFile(composeFiles, !*noParentSearch)
+	if err != nil {
+		return err
+	}
+
+	// Compose files from URLs or stdin have no directory of their own, so unless a project
+	// directory is given the project lives in the current directory: relative paths resolve
+	// against it and it names the project
+	cmdArgs.projectDirectory = *projectDirectory
+	if cmdArgs.projectDirectory == "" && hasNoDirectory(composePaths[0]) {
+		workingDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("getting working directory: %w", err)
+		}
+		cmdArgs.projectDirectory = workingDir
+	}
+
+	// JSON compose files are loaded from a rewritten copy, so the project stays in the
+	// directory of the original
+	if cmdArgs.projectDirectory == "" && isJSONComposeFile(composePaths[0], *fileFormat) {
+		projectDir, err := filepath.Abs(filepath.Dir(composePaths[0]))
+		if err != nil {
+			return fmt.Errorf("resolving project directory: %w", err)
+		}
+		cmdArgs.projectDirectory = projectDir
 	}
 
-	composePath, err := findComposeFile(*composeFile)
+	sourcePaths := composePaths
+	composePaths, cleanup, err := materializeComposeFiles(composePaths, remoteOptions{
+		headers: httpHeaders,
+		timeout: *httpTimeout,
+		refresh: *refresh,
+	}, *fileFormat)
 	if err != nil {
 		return err
 	}
+	defer cleanup()
+
+	if *locked {
+		lockPath := lockFilePath(composePaths, cmdArgs.projectDirectory)
+		if _, err := os.Stat(lockPath); err != nil {
+			return fmt.Errorf("lock file not found, run quay lock first: %w", err)
+		}
+		composePaths = append(composePaths, lockPath)
+	}
+
+	if settings.path != "" {
+		debugLog.Printf("Using settings file: %s", settings.path)
+	}
+	debugLog.Printf("Using compose file: %s", strings.Join(composePaths, ", "))
+
+	switch composeCmd {
+	case "config":
+		return executeConfigCommand(composePaths, cmdArgs)
+	case "services":
+		return executeServicesCommand(composePaths, cmdArgs)
+	case "ports":
+		return executePortsCommand(composePaths, cmdArgs)
+	case "diff":
+		return executeDiffCommand(composePaths, cmdArgs)
+	case "graph":
+		return executeGraphCommand(composePaths, cmdArgs)
+	case "lock":
+		return executeLockCommand(composePaths, cmdArgs)
+	case completeServicesCommand:
+		return executeCompleteServicesCommand(composePaths, cmdArgs)
+	}
+
+	if !cmdArgs.needsProjectRewrite() {
+		// The name is passed on so passthrough and filtered runs always agree on it
+		projectName, err := resolveProjectName(composePaths, cmdArgs)
+		if err != nil {
+			// Docker Compose loads the same files and reports the error itself
+			debugLog.Printf("Resolving the project name: %v", err)
+		}
+		// Docker Compose reads local JSON files itself, so it gets them rather than their rewritten copies
+		passthroughPaths := append([]string(nil), composePaths...)
+		for i, sourcePath := range sourcePaths {
+			if !hasNoDirectory(sourcePath) {
+				passthroughPaths[i] = sourcePath
+			}
+		}
+		return executePassthroughCommand(passthroughPaths, passthroughComposeArgs(projectName, composeCmd, cmdArgs), cmdArgs.dryRun, cmdArgs.showCommand)
+	}
 
-	if len(includeServices) == 0 && len(excludeServices) == 0 && len(portMappings) == 0 {
-		return executePassthroughCommand(composePath, args)
+	return executeFilteredCommand(composePaths, composeCmd, cmdArgs)
+}
+
+// versionString describes the quay build along with the compose-go version it was built with
+func versionString() string {
+	composeGoVersion := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/compose-spec/compose-go/v2" {
+				composeGoVersion = dep.Version
+			}
+		}
 	}
+	return fmt.Sprintf("quay %s (commit %s, built %s, compose-go %s)", version, commit, date, composeGoVersion)
+}
+
+// stringSliceFlag is a flag.Value that collects every occurrence of a repeatable flag in order
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// EnvOverride represents an environment variable to set on a service, or on every filtered
+// service when ServiceName is empty
+type EnvOverride struct {
+	ServiceName string
+	Key         string
+	Value       string
+}
 
-	return executeFilteredCommand(composePath, composeCmd, cmdOptions, includeServices, excludeServices, portMappings)
+// EnvUnset represents an environment variable to remove from a service
+type EnvUnset struct {
+	ServiceName string
+	Key         string
 }
 
-// PortMapping represents a port mapping for a service
-type PortMapping struct {
+// ImageOverride represents the image to run for a service instead of the configured one
+type ImageOverride struct {
+	ServiceName string
+	Image       string
+}
+
+// CommandOverride represents a command or entrypoint to run for a service instead of the
+// configured one. An empty Args clears the default of the image as well
+type CommandOverride struct {
+	ServiceName string
+	Args        []string
+}
+
+// VolumeMount represents a bind mount to add to a service
+type VolumeMount struct {
 	ServiceName   string
-	HostPort      string
-	ContainerPort string
+	HostPath      string
+	ContainerPath string
+	ReadOnly      bool
+}
+
+// ServiceScale represents the number of containers to run for a service
+type ServiceScale struct {
+	ServiceName string
+	Replicas    int
+}
+
+// Values of --exclude-deps-mode, deciding what happens to depends_on entries that reference
+// services which are not part of the filtered project
+const (
+	excludeDepsDrop  = "drop"
+	excludeDepsError = "error"
+	excludeDepsKeep  = "keep"
+)
+
+// commandsWithEnvFlag are the docker-compose commands that have their own -e/--env option,
+// which is passed through to them rather than read as a quay environment override
+var commandsWithEnvFlag = map[string]bool{"exec": true, "run": true}
+
+// commandsWithEntrypointFlag are the docker-compose commands that have their own --entrypoint
+// option, which is passed through to them rather than read as a quay entrypoint override
+var commandsWithEntrypointFlag = map[string]bool{"run": true}
+
+// commandsWithVolumeFlag are the docker-compose commands that have their own --volume option,
+// which is passed through to them rather than read as a quay bind mount
+var commandsWithVolumeFlag = map[string]bool{"run": true}
+
+// commandsWithFormatFlag are the commands that have their own --format option, which is passed
+// through to them rather than read as the format of the rendered project
+var commandsWithFormatFlag = map[string]bool{"graph": true, "images": true, "ls": true, "ps": true, "version": true}
+
+// Values of --format, the format in which a rendered project is written out
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+// commandArgs holds the quay-specific options extracted from the arguments
+// that follow the compose command, along with the options passed through as-is
+type commandArgs struct {
+	quay.FilterOptions
+	cmdOptions      []string
+	portMappings    []quay.PortMapping
+	noPorts         []string
+	portOffset      int
+	checkPorts      bool
+	pruneUnused     bool
+	excludeDepsMode string
+	force           bool
+	envOverrides    []EnvOverride
+	envUnsets       []EnvUnset
+	images          []ImageOverride
+	keepBuild       bool
+	commands        []CommandOverride
+	volumes         []VolumeMount
+	overrideFiles   []string
+	entrypoints     []CommandOverride
+	registry        string
+	replaceRegistry bool
+	scales          []ServiceScale
+	profiles        []string
+	envFiles        []string
+	projectName     string
+	groups          []string
+	// groupServices are the services of the selected groups by group name, which have to
+	// exist in the project
+	groupServices map[string][]string
+	// portTemplates are port mappings containing variables, which are expanded against the
+	// project environment and applied after portMappings
+	portTemplates []string
+	// defaultPortMappings come from the settings file and are applied before portMappings.
+	// Unlike those, mappings for services that are not part of the project are skipped silently
+	defaultPortMappings []quay.PortMapping
+	// projectDirectory overrides the directory of the first compose file as project directory
+	projectDirectory string
+	output           string
+	dryRun           bool
+	showCommand      bool
+	noRemoveOrphans  bool
+	format           string
+	strict           bool
+	// skipConsistencyCheck loads projects compose-go would reject, such as ones with depends_on cycles
+	skipConsistencyCheck bool
+}
+
+// needsProjectRewrite reports whether the compose project has to be loaded and rewritten
+// rather than passing the command straight through to docker-compose
+func (a commandArgs) needsProjectRewrite() bool {
+	return a.IncludeMode() || a.ExcludeMode() || len(a.portMappings) > 0 || len(a.portTemplates) > 0 || len(a.defaultPortMappings) > 0 || len(a.noPorts) > 0 || a.portOffset != 0 || len(a.envOverrides) > 0 || len(a.envUnsets) > 0 || len(a.images) > 0 || len(a.commands) > 0 || len(a.volumes) > 0 || len(a.overrideFiles) > 0 || len(a.entrypoints) > 0 || a.registry != "" || len(a.scales) > 0 || a.output != ""
 }
 
 // printUsage displays command line usage information and exits the program
@@ -78,270 +506,1944 @@ func printUsage(flagSet *flag.FlagSet) {
 	fmt.Println("\nOptions:")
 	flagSet.PrintDefaults()
 	fmt.Println("\nCommand options:")
-	fmt.Println("  --include SERVICE    Service to include (can be used multiple times)")
-	fmt.Println("  --exclude SERVICE    Service to exclude (can be used multiple times)")
-	fmt.Println("  --port SERVICE:HOST_PORT:CONTAINER_PORT    Redefine published port for a service")
-	fmt.Println("\nNote: --include and --exclude options cannot be used together")
+	fmt.Println("  --include SERVICE    Service to include (can be used multiple times or comma-separated)")
+	fmt.Println("  --exclude SERVICE    Service to exclude (can be used multiple times or comma-separated)")
+	fmt.Println("  --include-file PATH  Include the services listed in a file, one per line")
+	fmt.Println("  --exclude-file PATH  Exclude the services listed in a file, one per line")
+	fmt.Println("  --include-re REGEX   Include services whose whole name matches the regular expression")
+	fmt.Println("  --exclude-re REGEX   Exclude services whose whole name matches the regular expression")
+	fmt.Println("  --include-label KEY=VALUE    Include services with the given label (can be used multiple times)")
+	fmt.Println("  --select KEY=VALUE   Include services whose labels meet all selectors, KEY!=VALUE negates (can be used multiple times)")
+	fmt.Println("  --exclude-label KEY=VALUE    Exclude services with the given label (can be used multiple times)")
+	fmt.Println("  --port SERVICE:[HOST_IP:]HOST_PORT:CONTAINER_PORT[/PROTOCOL]    Redefine published port for a service (tcp or udp, default tcp)")
+	fmt.Println("  --port SERVICE:CONTAINER_PORT[/PROTOCOL]    Stop publishing a container port of a service")
+	fmt.Println("  --exclude-deps-mode drop|error|keep    Drop (default), fail on or keep depends_on entries for services that were filtered out")
+	fmt.Println("  --prune-unused       Drop top-level networks and volumes no remaining service uses")
+	fmt.Println("  --check-ports        Warn about published host ports already in use on this machine")
+	fmt.Println("  --force              Only warn about conflicting published ports")
+	fmt.Println("  --port-offset N      Add N to every published host port")
+	fmt.Println("  --no-ports SERVICE   Stop publishing all ports of a service (supports glob patterns)")
+	fmt.Println("  --env, -e [SERVICE:]KEY=VALUE  Set an environment variable on a service, or on all filtered services (can be used multiple times, not for run and exec)")
+	fmt.Println("  --unset-env SERVICE:KEY[,KEY...]    Remove environment variables from a service, including ones from env_file")
+	fmt.Println("  --image SERVICE=IMAGE[:TAG]    Run a different image for a service, dropping its build section")
+	fmt.Println("  --registry PREFIX    Prefix every service image with a registry path")
+	fmt.Println("  --registry-replace   Replace the registry named in images with the --registry prefix")
+	fmt.Println("  --command SERVICE=COMMAND    Run a different command for a service (empty for none, not even the image default)")
+	fmt.Println("  --entrypoint SERVICE=COMMAND    Use a different entrypoint for a service (empty for none, not even the image default)")
+	fmt.Println("  --override PATH      Merge a compose file fragment into the filtered project (can be used multiple times)")
+	fmt.Println("  --volume SERVICE:HOST_PATH:CONTAINER_PATH[:ro|rw]    Bind mount a host path into a service (can be used multiple times)")
+	fmt.Println("  --keep-build         Keep the build section of services given an image with --image")
+	fmt.Println("  --scale SERVICE=COUNT    Number of containers to run for a service (0 defines it without starting it)")
+	fmt.Println("  --profile NAME       Enable services of a compose profile (can be used multiple times, defaults to COMPOSE_PROFILES or .quay.yml)")
+	fmt.Println("  --group NAME         Include the services of a group defined in .quay.yml (can be used multiple times)")
+	fmt.Println("  --with-deps, --include-deps    Also include services that included services depend on")
+	fmt.Println("  --include-dependents Also include services that depend on included services")
+	fmt.Println("  --no-keep-required   Fail instead of keeping services referenced by network_mode, ipc, pid, volumes_from or links")
+	fmt.Println("  --format yaml|json   Format of the compose file printed by config, --dry-run and -o (default yaml)")
+	fmt.Println("  --dry-run            Print the generated compose file (or docker-compose command) instead of running it")
+	fmt.Println("  --show-command       Print the docker-compose command to stderr before running it")
+	fmt.Println("  --no-remove-orphans  Keep containers of filtered out services running; by default a filtered up adds --remove-orphans")
+	fmt.Println("  --strict             Fail instead of warning when requested services are not found (or set QUAY_STRICT=1)")
+	fmt.Println("\nNote: include options (--include, --include-re, --include-label, --select) and exclude options (--exclude, --exclude-re, --exclude-label) cannot be used together")
+	fmt.Println("Service names given to --include and --exclude may be shell-style glob patterns (*, ?, [...])")
 	fmt.Println("\nExamples:")
 	fmt.Println("  quay up -d                           # Run all services")
 	fmt.Println("  quay up -d --include web --include db  # Run only web and db services")
+	fmt.Println("  quay up -d --include web,db,redis      # Same, using a comma-separated list")
 	fmt.Println("  quay up -d --exclude web               # Run all services except web")
+	fmt.Println("  quay up -d --include 'worker-*'        # Run all services whose names start with worker-")
+	fmt.Println("  quay up -d --include-re 'api-(users|orders)'  # Run services matching a regular expression")
+	fmt.Println("  quay up -d --include web --with-deps   # Run web and everything it depends on")
+	fmt.Println("  quay restart --include db --include-dependents  # Restart db and everything that uses it")
+	fmt.Println("  quay up -d --include-label quay.group=backend  # Run all services labeled quay.group=backend")
+	fmt.Println("  quay up -d --profile debug --include pgadmin   # Run a service from the debug profile")
 	fmt.Println("  quay -f custom.yml up --include redis  # Use custom compose file")
+	fmt.Println("  quay -f base.yml -f override.yml up -d # Merge several compose files")
 	fmt.Println("  quay up -d --port web:8080:80          # Run with web service port 80 published to host port 8080")
+	fmt.Println("  quay up -d --port dns:5353:53/udp      # Publish UDP port 53 of dns on host port 5353")
+	fmt.Println("  quay up -d --port db:5432              # Keep db port 5432 internal to the compose network")
+	fmt.Println("  quay up -d --port web:127.0.0.1:8080:80  # Publish web port 80 on localhost only")
+	fmt.Println("  quay up -d --port media:8000-8010:9000-9010  # Publish a range of container ports")
+	fmt.Println("  quay up -d --port web:0:80             # Publish web port 80 on a free host port and print it")
+	fmt.Println("  quay up -d --port-offset 1000          # Publish all ports 1000 higher than configured")
+	fmt.Println("  quay up -d --no-ports '*' --port web:8081:80  # Publish only web port 80 on host port 8081")
+	fmt.Println("  quay up -d --include worker --scale worker=4  # Run four worker containers")
+	fmt.Println("  quay up --dry-run --include web > rendered.yml  # Print the filtered compose file")
+	fmt.Println("  quay -o rendered.yml up --include web  # Save the filtered compose file")
+	fmt.Println("  quay up -d --env web:LOG_LEVEL=debug   # Run with LOG_LEVEL set to debug in the web service")
+	fmt.Println("  quay up -d --include api --image api=registry/api:pr-423  # Run api from a locally built image")
+	fmt.Println("  quay --env-file .env.staging up -d     # Use .env.staging instead of .env")
+	fmt.Println("  quay lock                              # Pin all images to their current digests in quay.lock.yml")
+	fmt.Println("  quay --locked up -d                    # Run with the images pinned by quay lock")
+	fmt.Println("  quay up -d --command 'api=sleep infinity'  # Keep api running without starting the application")
+	fmt.Println("  quay completion bash                   # Print a bash completion script (also zsh and fish)")
+	fmt.Println("  quay config --include web              # Validate and print the effective compose file")
+	fmt.Println("  quay config --services --include 'api-*'  # List the services that would be selected")
+	fmt.Println("  quay services --exclude db             # Show which services the filter selects")
+	fmt.Println("  quay services -q --exclude db          # List the services left after filtering")
+	fmt.Println("  quay ports --port web:9090:80 --live   # Compare the effective port mappings with the running containers")
+	fmt.Println("  quay diff --include web --env web:DEBUG=1  # Show what filtering and overrides change")
+	fmt.Println("  quay up -d --group backend             # Run the services of the backend group defined in .quay.yml")
+	fmt.Println("  quay groups                            # List the groups defined in .quay.yml")
+	// Scripts may rely on bare quay failing with 1, so usage keeps that status rather than quayErrorExitCode
 	os.Exit(1)
 }
 
 // parseRemainingArgs separates command options from service names in the argument list
 // It extracts services specified with --include/--exclude and returns command options and services
-func parseRemainingArgs(args []string) (cmdOptions, includeServices, excludeServices []string, portMappings []PortMapping) {
+func parseRemainingArgs(composeCmd string, args []string) (commandArgs, error) {
+	var cmdArgs commandArgs
 	for i := 0; i < len(args); i++ {
 		if args[i] == "--include" && i+1 < len(args) {
-			includeServices = append(includeServices, args[i+1])
+			cmdArgs.IncludeServices = append(cmdArgs.IncludeServices, splitList(args[i+1])...)
 			i++ // Skip the next argument as it's the service name
 		} else if args[i] == "--exclude" && i+1 < len(args) {
-			excludeServices = append(excludeServices, args[i+1])
+			cmdArgs.ExcludeServices = append(cmdArgs.ExcludeServices, splitList(args[i+1])...)
 			i++ // Skip the next argument as it's the service name
-		} else if args[i] == "--port" && i+1 < len(args) {
-			// Parse port mapping in format service:host_port:container_port
-			portMapping, err := parsePortMapping(args[i+1])
+		} else if (args[i] == "--include-file" || args[i] == "--exclude-file") && i+1 < len(args) {
+			services, err := readServiceList(args[i+1])
 			if err != nil {
-				fmt.Printf("Warning: Invalid port mapping format '%s': %v\n", args[i+1], err)
+				return commandArgs{}, err
+			}
+			if args[i] == "--include-file" {
+				cmdArgs.IncludeServices = append(cmdArgs.IncludeServices, services...)
+			} else {
+				cmdArgs.ExcludeServices = append(cmdArgs.ExcludeServices, services...)
+			}
+			i++ // Skip the next argument as it's the file path
+		} else if (args[i] == "--include-re" || args[i] == "--exclude-re") && i+1 < len(args) {
+			// Fail fast on invalid expressions rather than silently selecting nothing
+			if _, err := quay.CompileNameRegexps([]string{args[i+1]}); err != nil {
+				return commandArgs{}, err
+			}
+			if args[i] == "--include-re" {
+				cmdArgs.IncludeRegexps = append(cmdArgs.IncludeRegexps, args[i+1])
+			} else {
+				cmdArgs.ExcludeRegexps = append(cmdArgs.ExcludeRegexps, args[i+1])
+			}
+			i++ // Skip the next argument as it's the regular expression
+		} else if (args[i] == "--include-label" || args[i] == "--exclude-label") && i+1 < len(args) {
+			// Parse label selector in format key=value
+			if key, _, found := strings.Cut(args[i+1], "="); !found || key == "" {
+				return commandArgs{}, fmt.Errorf("invalid label selector '%s': expected KEY=VALUE", args[i+1])
+			}
+			if args[i] == "--include-label" {
+				cmdArgs.IncludeLabels = append(cmdArgs.IncludeLabels, args[i+1])
 			} else {
-				portMappings = append(portMappings, portMapping)
+				cmdArgs.ExcludeLabels = append(cmdArgs.ExcludeLabels, args[i+1])
+			}
+			i++ // Skip the next argument as it's the label selector
+		} else if args[i] == "--select" && i+1 < len(args) {
+			key, _, found := strings.Cut(strings.Replace(args[i+1], "!=", "=", 1), "=")
+			if !found || key == "" {
+				return commandArgs{}, fmt.Errorf("invalid selector '%s': expected KEY=VALUE or KEY!=VALUE", args[i+1])
+			}
+			cmdArgs.Selectors = append(cmdArgs.Selectors, args[i+1])
+			i++ // Skip the next argument as it's the selector
+		} else if args[i] == "--scale" && i+1 < len(args) {
+			// Parse scale in format service=count
+			for _, value := range splitList(args[i+1]) {
+				scale, err := parseScale(value)
+				if err != nil {
+					return commandArgs{}, fmt.Errorf("invalid scale '%s': %w", value, err)
+				}
+				cmdArgs.scales = append(cmdArgs.scales, scale)
+			}
+			i++ // Skip the next argument as it's the scale
+		} else if args[i] == "--group" && i+1 < len(args) {
+			cmdArgs.groups = append(cmdArgs.groups, splitList(args[i+1])...)
+			i++ // Skip the next argument as it's the group name
+		} else if args[i] == "--profile" && i+1 < len(args) {
+			cmdArgs.profiles = append(cmdArgs.profiles, splitList(args[i+1])...)
+			i++ // Skip the next argument as it's the profile name
+		} else if args[i] == "--with-deps" || args[i] == "--include-deps" {
+			cmdArgs.WithDeps = true
+		} else if args[i] == "--include-dependents" {
+			cmdArgs.WithDependents = true
+		} else if args[i] == "--keep-required" {
+			cmdArgs.FailOnRequired = false
+		} else if args[i] == "--no-keep-required" {
+			cmdArgs.FailOnRequired = true
+		} else if args[i] == "--exclude-deps-mode" || strings.HasPrefix(args[i], "--exclude-deps-mode=") {
+			mode, hasValue := strings.CutPrefix(args[i], "--exclude-deps-mode=")
+			if !hasValue && i+1 < len(args) {
+				mode = args[i+1]
+				i++ // Skip the next argument as it's the mode
+			}
+			if mode != excludeDepsDrop && mode != excludeDepsError && mode != excludeDepsKeep {
+				return commandArgs{}, fmt.Errorf("invalid exclude deps mode '%s': expected drop, error or keep", mode)
+			}
+			cmdArgs.excludeDepsMode = mode
+		} else if args[i] == "--prune-unused" {
+			cmdArgs.pruneUnused = true
+		} else if args[i] == "--check-ports" {
+			cmdArgs.checkPorts = true
+		} else if args[i] == "--force" {
+			cmdArgs.force = true
+		} else if args[i] == "--dry-run" {
+			cmdArgs.dryRun = true
+		} else if args[i] == "--show-command" {
+			cmdArgs.showCommand = true
+		} else if args[i] == "--no-remove-orphans" {
+			cmdArgs.noRemoveOrphans = true
+		} else if args[i] == "--format" && !commandsWithFormatFlag[composeCmd] && i+1 < len(args) {
+			if args[i+1] != formatYAML && args[i+1] != formatJSON {
+				return commandArgs{}, fmt.Errorf("invalid format '%s': expected %s or %s", args[i+1], formatYAML, formatJSON)
+			}
+			cmdArgs.format = args[i+1]
+			i++ // Skip the next argument as it's the format
+		} else if args[i] == "--strict" {
+			cmdArgs.strict = true
+		} else if args[i] == "--port" && i+1 < len(args) {
+			// Parse port mappings in format service:host_port:container_port
+			for _, mapping := range splitList(args[i+1]) {
+				// Variables are resolved once the project environment is loaded
+				if strings.Contains(mapping, "$") {
+					cmdArgs.portTemplates = append(cmdArgs.portTemplates, mapping)
+					continue
+				}
+
+				portMappings, err := quay.ParsePortMapping(mapping)
+				if err != nil {
+					return commandArgs{}, fmt.Errorf("invalid port mapping '%s': %w", mapping, err)
+				}
+				cmdArgs.portMappings = append(cmdArgs.portMappings, portMappings...)
 			}
 			i++ // Skip the next argument as it's the port mapping
+		} else if (args[i] == "--env" || args[i] == "-e") && !commandsWithEnvFlag[composeCmd] && i+1 < len(args) {
+			// Values may contain commas, so unlike --port this takes a single override
+			override, err := parseEnvOverride(args[i+1])
+			if err != nil {
+				return commandArgs{}, fmt.Errorf("invalid environment override '%s': %w", args[i+1], err)
+			}
+			cmdArgs.envOverrides = append(cmdArgs.envOverrides, override)
+			i++ // Skip the next argument as it's the environment override
+		} else if args[i] == "--unset-env" && i+1 < len(args) {
+			unsets, err := parseEnvUnsets(args[i+1])
+			if err != nil {
+				return commandArgs{}, fmt.Errorf("invalid environment unset '%s': %w", args[i+1], err)
+			}
+			cmdArgs.envUnsets = append(cmdArgs.envUnsets, unsets...)
+			i++ // Skip the next argument as it's the environment variable list
+		} else if args[i] == "--image" && i+1 < len(args) {
+			for _, value := range splitList(args[i+1]) {
+				image, err := parseImageOverride(value)
+				if err != nil {
+					return commandArgs{}, fmt.Errorf("invalid image override '%s': %w", value, err)
+				}
+				cmdArgs.images = append(cmdArgs.images, image)
+			}
+			i++ // Skip the next argument as it's the image override
+		} else if args[i] == "--registry" && i+1 < len(args) {
+			cmdArgs.registry = strings.TrimSuffix(args[i+1], "/")
+			i++ // Skip the next argument as it's the registry prefix
+		} else if args[i] == "--registry-replace" {
+			cmdArgs.replaceRegistry = true
+		} else if (args[i] == "--command" || (args[i] == "--entrypoint" && !commandsWithEntrypointFlag[composeCmd])) && i+1 < len(args) {
+			override, err := parseCommandOverride(args[i+1])
+			if err != nil {
+				return commandArgs{}, fmt.Errorf("invalid %s override '%s': %w", strings.TrimPrefix(args[i], "--"), args[i+1], err)
+			}
+			if args[i] == "--command" {
+				cmdArgs.commands = append(cmdArgs.commands, override)
+			} else {
+				cmdArgs.entrypoints = append(cmdArgs.entrypoints, override)
+			}
+			i++ // Skip the next argument as it's the command override
+		} else if args[i] == "--override" && i+1 < len(args) {
+			cmdArgs.overrideFiles = append(cmdArgs.overrideFiles, args[i+1])
+			i++ // Skip the next argument as it's the override file
+		} else if args[i] == "--volume" && !commandsWithVolumeFlag[composeCmd] && i+1 < len(args) {
+			volume, err := parseVolumeMount(args[i+1])
+			if err != nil {
+				return commandArgs{}, fmt.Errorf("invalid volume '%s': %w", args[i+1], err)
+			}
+			cmdArgs.volumes = append(cmdArgs.volumes, volume)
+			i++ // Skip the next argument as it's the volume
+		} else if args[i] == "--keep-build" {
+			cmdArgs.keepBuild = true
+		} else if args[i] == "--port-offset" && i+1 < len(args) {
+			// An offset beyond the number of ports would move every port out of range
+			offset, err := strconv.Atoi(args[i+1])
+			if err != nil || offset < -65534 || offset > 65534 {
+				return commandArgs{}, fmt.Errorf("invalid port offset '%s': expected an integer between -65534 and 65534", args[i+1])
+			}
+			cmdArgs.portOffset = offset
+			i++ // Skip the next argument as it's the port offset
+		} else if args[i] == "--no-ports" && i+1 < len(args) {
+			cmdArgs.noPorts = append(cmdArgs.noPorts, splitList(args[i+1])...)
+			i++ // Skip the next argument as it's the service name
 		} else {
-			cmdOptions = append(cmdOptions, args[i])
+			cmdArgs.cmdOptions = append(cmdArgs.cmdOptions, args[i])
+		}
+	}
+	return cmdArgs, nil
+}
+
+// splitList splits a comma-separated flag value into its trimmed, non-empty elements
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
-	return cmdOptions, includeServices, excludeServices, portMappings
+	return items
 }
 
-// parsePortMapping parses a port mapping string in the format service:host_port:container_port
-func parsePortMapping(mapping string) (PortMapping, error) {
-	re := regexp.MustCompile(`^([^:]+):(\d+):(\d+)$`)
-	matches := re.FindStringSubmatch(mapping)
+// readServiceList reads service names from a file, one per line. Blank lines and
+// lines starting with '#' are ignored
+func readServiceList(filePath string) ([]string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading service list: %w", err)
+	}
 
-	if matches == nil || len(matches) != 4 {
-		return PortMapping{}, fmt.Errorf("invalid format, expected SERVICE:HOST_PORT:CONTAINER_PORT")
+	var services []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		services = append(services, line)
 	}
+	return services, nil
+}
+
+// expandPortTemplates resolves ${VAR} and $VAR references in port mappings against the project
+// environment, which includes the .env and --env-file files, and parses the results. Defaults
+// like ${VAR:-8080} are honored, while a variable that is not set and has no default is an error
+func expandPortTemplates(templates []string, environment types.Mapping) ([]quay.PortMapping, error) {
+	var portMappings []quay.PortMapping
+	for _, portTemplate := range templates {
+		for name, variable := range template.ExtractVariables(map[string]interface{}{"port": portTemplate}, nil) {
+			if _, set := environment[name]; !set && variable.DefaultValue == "" && variable.PresenceValue == "" {
+				return nil, fmt.Errorf("invalid port mapping '%s': variable %s is not set", portTemplate, name)
+			}
+		}
+
+		value, err := template.Substitute(portTemplate, environment.Resolve)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port mapping '%s': %w", portTemplate, err)
+		}
 
-	serviceName := matches[1]
-	hostPort := matches[2]
-	containerPort := matches[3]
+		mappings, err := quay.ParsePortMapping(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port mapping '%s' (expanded to '%s'): %w", portTemplate, value, err)
+		}
+		portMappings = append(portMappings, mappings...)
+	}
+	return portMappings, nil
+}
 
-	// Validate port numbers
-	if _, err := strconv.Atoi(hostPort); err != nil {
-		return PortMapping{}, fmt.Errorf("invalid host port: %s", hostPort)
+// parseEnvOverride parses an environment override in the format [service:]key=value.
+// Everything after the first '=' is the value, which may be empty and may contain ':'
+func parseEnvOverride(value string) (EnvOverride, error) {
+	name, envValue, found := strings.Cut(value, "=")
+	if !found {
+		return EnvOverride{}, fmt.Errorf("invalid format, expected [SERVICE:]KEY=VALUE")
 	}
 
-	if _, err := strconv.Atoi(containerPort); err != nil {
-		return PortMapping{}, fmt.Errorf("invalid container port: %s", containerPort)
+	serviceName, key, hasService := strings.Cut(name, ":")
+	if !hasService {
+		serviceName, key = "", name
+	}
+	if key == "" || (hasService && serviceName == "") {
+		return EnvOverride{}, fmt.Errorf("invalid format, expected [SERVICE:]KEY=VALUE")
 	}
 
-	return PortMapping{
-		ServiceName:   serviceName,
-		HostPort:      hostPort,
-		ContainerPort: containerPort,
-	}, nil
+	return EnvOverride{ServiceName: serviceName, Key: key, Value: envValue}, nil
 }
 
-// findComposeFile locates a Docker Compose file to use, either the specified file
-// or one of the default files if none is specified
-func findComposeFile(specifiedFile string) (string, error) {
-	if specifiedFile != "" {
-		return specifiedFile, nil
+// parseEnvUnsets parses environment variables to remove in the format service:key1,key2
+func parseEnvUnsets(value string) ([]EnvUnset, error) {
+	serviceName, keys, found := strings.Cut(value, ":")
+	if !found || serviceName == "" || len(splitList(keys)) == 0 {
+		return nil, fmt.Errorf("invalid format, expected SERVICE:KEY[,KEY...]")
 	}
 
-	for _, filename := range []string{defaultComposeFile1, defaultComposeFile2} {
-		if _, err := os.Stat(filename); err == nil {
-			return filename, nil
-		}
+	var unsets []EnvUnset
+	for _, key := range splitList(keys) {
+		unsets = append(unsets, EnvUnset{ServiceName: serviceName, Key: key})
 	}
-
-	return "", fmt.Errorf("no docker-compose file found")
+	return unsets, nil
 }
 
-// executePassthroughCommand runs docker-compose with all arguments passed through
-// without any service filtering
-func executePassthroughCommand(composePath string, args []string) error {
-	dockerComposeArgs := []string{"-f", composePath}
-	dockerComposeArgs = append(dockerComposeArgs, args...)
+// parseImageOverride parses an image override in the format service=image[:tag]
+func parseImageOverride(value string) (ImageOverride, error) {
+	serviceName, image, found := strings.Cut(value, "=")
+	if !found || serviceName == "" || image == "" {
+		return ImageOverride{}, fmt.Errorf("invalid format, expected SERVICE=IMAGE[:TAG]")
+	}
 
-	cmd := exec.Command("docker-compose", dockerComposeArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if _, err := reference.ParseNormalizedNamed(image); err != nil {
+		return ImageOverride{}, fmt.Errorf("invalid image reference: %w", err)
+	}
 
-	return cmd.Run()
+	return ImageOverride{ServiceName: serviceName, Image: image}, nil
 }
 
-// executeFilteredCommand loads a Docker Compose project, filters it to only include
-// the specified services, and then runs docker-compose with those services
-func executeFilteredCommand(composePath, composeCmd string, cmdOptions, includeServices, excludeServices []string, portMappings []PortMapping) error {
-	ctx := context.Background()
+// parseCommandOverride parses a command override in the format service=command, splitting the
+// command into arguments like a shell would so quoted arguments stay together
+func parseCommandOverride(value string) (CommandOverride, error) {
+	serviceName, command, found := strings.Cut(value, "=")
+	if !found || serviceName == "" {
+		return CommandOverride{}, fmt.Errorf("invalid format, expected SERVICE=COMMAND")
+	}
 
-	projectOptions, err := cli.NewProjectOptions(
-		[]string{composePath},
-		cli.WithOsEnv,
-		cli.WithDotEnv,
-	)
+	args, err := shellwords.Parse(command)
 	if err != nil {
-		return fmt.Errorf("creating project options: %w", err)
+		return CommandOverride{}, err
 	}
 
-	project, err := projectOptions.LoadProject(ctx)
-	if err != nil {
-		return fmt.Errorf("loading project: %w", err)
+	// An empty command clears the one of the image, which takes an empty rather than a nil
+	// slice, since nil leaves the setting out of the rendered file
+	if len(args) == 0 {
+		args = []string{}
 	}
 
-	filteredProject, missingServices := filterServices(project, includeServices, excludeServices)
+	return CommandOverride{ServiceName: serviceName, Args: args}, nil
+}
 
-	// Apply port mappings to filtered project
-	missingPortServices := applyPortMappings(filteredProject, portMappings)
-	missingServices = append(missingServices, missingPortServices...)
+// parseVolumeMount parses a bind mount in the format service:host_path:container_path[:ro|rw].
+// The container path is taken from the end, so host paths may contain colons themselves
+func parseVolumeMount(value string) (VolumeMount, error) {
+	formatErr := fmt.Errorf("invalid format, expected SERVICE:HOST_PATH:CONTAINER_PATH[:ro|rw]")
 
-	if len(missingServices) > 0 {
-		fmt.Println("Warning: Some requested services were not found in the docker-compose file:")
-		for _, name := range missingServices {
-			fmt.Printf("  - %s\n", name)
-		}
+	serviceName, paths, found := strings.Cut(value, ":")
+	if !found || serviceName == "" {
+		return VolumeMount{}, formatErr
 	}
 
-	yamlData, err := yaml.Marshal(filteredProject)
-	if err != nil {
-		return fmt.Errorf("marshaling filtered project: %w", err)
+	parts := strings.Split(paths, ":")
+	readOnly := false
+	if last := parts[len(parts)-1]; last == "ro" || last == "rw" {
+		readOnly = last == "ro"
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) < 2 {
+		return VolumeMount{}, formatErr
+	}
+
+	hostPath := strings.Join(parts[:len(parts)-1], ":")
+	containerPath := parts[len(parts)-1]
+	if hostPath == "" {
+		return VolumeMount{}, formatErr
+	}
+	if !path.IsAbs(containerPath) {
+		return VolumeMount{}, fmt.Errorf("container path %s is not absolute", containerPath)
 	}
 
-	dockerComposeArgs := []string{"-f", "-", composeCmd}
-	dockerComposeArgs = append(dockerComposeArgs, cmdOptions...)
+	return VolumeMount{
+		ServiceName:   serviceName,
+		HostPath:      hostPath,
+		ContainerPath: containerPath,
+		ReadOnly:      readOnly,
+	}, nil
+}
 
-	if composeCmd == "up" && !containsRemoveOrphans(cmdOptions) {
-		dockerComposeArgs = append(dockerComposeArgs, "--remove-orphans")
+// parseScale parses a scale string in the format service=count, where count is a non-negative integer
+func parseScale(value string) (ServiceScale, error) {
+	serviceName, count, found := strings.Cut(value, "=")
+	if !found || serviceName == "" {
+		return ServiceScale{}, fmt.Errorf("invalid format, expected SERVICE=COUNT")
 	}
 
-	cmd := exec.Command("docker-compose", dockerComposeArgs...)
-	cmd.Stdin = strings.NewReader(string(yamlData))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	replicas, err := strconv.Atoi(count)
+	if err != nil || replicas < 0 {
+		return ServiceScale{}, fmt.Errorf("invalid count: %s, expected a non-negative integer", count)
+	}
 
-	return cmd.Run()
+	return ServiceScale{
+		ServiceName: serviceName,
+		Replicas:    replicas,
+	}, nil
 }
 
-// applyPortMappings modifies service port mappings in the filtered project
-// and returns a list of services that were requested but not found
-func applyPortMappings(project *types.Project, portMappings []PortMapping) []string {
-	var missingServices []string
+// findComposeFile locates the Docker Compose files to use, either the specified files
+// in the order given, the files listed in COMPOSE_FILE, or one of the default files if none
+// is specified. Default files are searched for in the current directory and then in its
+// parents, stopping at the filesystem root or at the first directory containing .git, unless
+// searchParents is false. Like docker-compose, a default file is merged with its override file
+// when there is one. The directory of the file found becomes the project directory
+func findComposeFile(specifiedFiles []string, searchParents bool) ([]string, error) {
+	if len(specifiedFiles) > 0 {
+		return specifiedFiles, nil
+	}
 
-	for _, mapping := range portMappings {
-		service, exists := project.Services[mapping.ServiceName]
-		if !exists {
-			missingServices = append(missingServices, mapping.ServiceName)
-			continue
+	if composeFiles := composeFilesFromEnv(); len(composeFiles) > 0 {
+		for _, composeFile := range composeFiles {
+			if _, err := os.Stat(composeFile); err != nil {
+				return nil, fmt.Errorf("compose file listed in COMPOSE_FILE not found: %w", err)
+			}
 		}
+		return composeFiles, nil
+	}
+
+	if composePath, found := defaultComposeFileIn("."); found {
+		return withOverrideFile(composePath), nil
+	}
 
-		// Parse string ports to integers
-		containerPort, _ := strconv.ParseUint(mapping.ContainerPort, 10, 32)
-		containerPortUint32 := uint32(containerPort)
+	if !searchParents {
+		return nil, fmt.Errorf("no compose file found in the current directory (looked for %s)", strings.Join(defaultComposeFiles, ", "))
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getting working directory: %w", err)
+	}
 
-		// Create or update the ports configuration for the service
-		newPort := types.ServicePortConfig{
-			Published: mapping.HostPort,
-			Target:    containerPortUint32,
-			Protocol:  "tcp", // Default to TCP protocol
+	for !isProjectBoundary(dir) {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
 		}
+		dir = parent
 
-		// Check if there's an existing port mapping for the container port
-		portUpdated := false
-		for i, port := range service.Ports {
-			if port.Target == containerPortUint32 {
-				// Update the existing port mapping
-				service.Ports[i].Published = mapping.HostPort
-				portUpdated = true
-				break
-			}
+		if composePath, found := defaultComposeFileIn(dir); found {
+			return withOverrideFile(composePath), nil
 		}
+	}
+
+	return nil, fmt.Errorf("no compose file found (looked for %s)", strings.Join(defaultComposeFiles, ", "))
+}
 
-		// If no existing mapping was found, add a new one
-		if !portUpdated {
-			service.Ports = append(service.Ports, newPort)
+// defaultComposeFileIn returns the preferred default compose file in a directory, noting
+// which one is used when the directory contains several
+func defaultComposeFileIn(dir string) (string, bool) {
+	var candidates []string
+	for _, filename := range defaultComposeFiles {
+		if _, err := os.Stat(filepath.Join(dir, filename)); err == nil {
+			candidates = append(candidates, filename)
 		}
+	}
 
-		// Update the service in the project
-		project.Services[mapping.ServiceName] = service
+	if len(candidates) == 0 {
+		return "", false
 	}
 
-	return missingServices
+	composePath := candidates[0]
+	if dir != "." {
+		composePath = filepath.Join(dir, composePath)
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(diagnostics, "Found multiple compose files (%s), using %s\n", strings.Join(candidates, ", "), composePath)
+	}
+	return composePath, true
 }
 
-// filterServices creates a filtered version of the project containing only the requested services
-// and returns a list of any services that were requested but not found
-func filterServices(project *types.Project, includeServices, excludeServices []string) (*types.Project, []string) {
-	// Convert include and exclude services to maps for quick lookup
-	includeMap := make(map[string]bool)
-	for _, service := range includeServices {
-		includeMap[service] = true
+// withOverrideFile returns the default compose file followed by its override file, such as
+// docker-compose.override.yml for docker-compose.yml, when the override file exists
+func withOverrideFile(composePath string) []string {
+	base := strings.TrimSuffix(composePath, filepath.Ext(composePath))
+	for _, extension := range []string{".yml", ".yaml"} {
+		override := base + ".override" + extension
+		if _, err := os.Stat(override); err == nil {
+			return []string{composePath, override}
+		}
 	}
+	return []string{composePath}
+}
 
-	excludeMap := make(map[string]bool)
-	for _, service := range excludeServices {
-		excludeMap[service] = true
-	}
+// composeFilesFromEnv returns the compose files listed in COMPOSE_FILE
+func composeFilesFromEnv() []string {
+	return splitComposeFileList(os.Getenv("COMPOSE_FILE"))
+}
 
-	// Track which services we couldn't find
-	missingIncludeServices := make(map[string]bool)
-	for service := range includeMap {
-		missingIncludeServices[service] = true
+// splitComposeFileList splits a list of compose files like COMPOSE_FILE does, on
+// COMPOSE_PATH_SEPARATOR or the platform path list separator
+func splitComposeFileList(value string) []string {
+	separator := os.Getenv("COMPOSE_PATH_SEPARATOR")
+	if separator == "" {
+		separator = string(os.PathListSeparator)
 	}
 
-	missingExcludeServices := make(map[string]bool)
-	for service := range excludeMap {
-		missingExcludeServices[service] = true
+	var composeFiles []string
+	for _, composeFile := range strings.Split(value, separator) {
+		if composeFile != "" {
+			composeFiles = append(composeFiles, composeFile)
+		}
 	}
+	return composeFiles
+}
 
-	// Create a filtered version of the project services
-	filteredServices := types.Services{}
+// isProjectBoundary reports whether a directory is the root of a git checkout,
+// which ends the upward search for a compose file
+func isProjectBoundary(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
 
-	// If include services are specified, only include those services
-	// If only exclude services are specified, include all except those
-	usingIncludeMode := len(includeServices) > 0
+// composeBinOverride is the Docker Compose command given with --compose-bin or QUAY_COMPOSE_BIN.
+// It is split like a shell would, so it may include arguments, such as "nerdctl compose"
+var composeBinOverride string
 
-	for name, service := range project.Services {
-		if usingIncludeMode {
-			// Include mode: only add services that are explicitly included
-			if includeMap[name] {
-				filteredServices[name] = service
-				delete(missingIncludeServices, name)
-			}
-		} else {
-			// Exclude mode: add all services except those explicitly excluded
-			if !excludeMap[name] {
-				filteredServices[name] = service
-			} else {
-				delete(missingExcludeServices, name)
-			}
+// composeCommand caches the Docker Compose command detected by resolveComposeCommand, so
+// detection runs at most once per process
+var composeCommand struct {
+	once   sync.Once
+	bin    string
+	prefix []string
+	err    error
+}
+
+// resolveComposeCommand determines how to invoke Docker Compose and returns the executable
+// along with the arguments that must precede the compose arguments
+func resolveComposeCommand() (string, []string, error) {
+	composeCommand.once.Do(func() {
+		composeCommand.bin, composeCommand.prefix, composeCommand.err = detectComposeCommand(composeBinOverride, runCommand)
+		if composeCommand.err == nil {
+			debugLog.Printf("Using Docker Compose: %s", strings.Join(append([]string{composeCommand.bin}, composeCommand.prefix...), " "))
+		}
+	})
+	return composeCommand.bin, composeCommand.prefix, composeCommand.err
+}
+
+// detectComposeCommand picks the Docker Compose command: the override when one is set,
+// otherwise the docker compose v2 plugin and then the standalone docker-compose binary,
+// whichever answers "version" first
+func detectComposeCommand(override string, run commandRunner) (string, []string, error) {
+	fields, err := shellwords.Parse(override)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid compose command '%s': %w", override, err)
+	}
+	if len(fields) > 0 {
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			return "", nil, fmt.Errorf("compose command not found: %w", err)
 		}
+		return fields[0], fields[1:], nil
 	}
 
-	// Collect missing services for error reporting
-	var missingServices []string
-	for service := range missingIncludeServices {
-		missingServices = append(missingServices, service)
+	if _, err := run("docker", "compose", "version"); err == nil {
+		return "docker", []string{"compose"}, nil
+	}
+
+	if _, err := run("docker-compose", "version"); err == nil {
+		return "docker-compose", nil, nil
+	}
+
+	return "", nil, fmt.Errorf("docker compose not found: install the docker compose plugin or docker-compose, or set --compose-bin or %s", composeBinEnv)
+}
+
+// composeFileArgs builds the -f arguments for docker-compose, preserving the file order
+func composeFileArgs(composePaths []string) []string {
+	var fileArgs []string
+	for _, composePath := range composePaths {
+		fileArgs = append(fileArgs, "-f", composePath)
+	}
+	return fileArgs
+}
+
+// projectNameArgs builds the -p argument for docker-compose when a project name is set
+func projectNameArgs(projectName string) []string {
+	if projectName == "" {
+		return nil
 	}
-	for service := range missingExcludeServices {
-		missingServices = append(missingServices, service)
+	return []string{"-p", projectName}
+}
+
+// projectDirectoryArgs builds the --project-directory argument for docker-compose when a
+// project directory is set
+func projectDirectoryArgs(projectDirectory string) []string {
+	if projectDirectory == "" {
+		return nil
 	}
+	return []string{"--project-directory", projectDirectory}
+}
 
-	// Create a filtered project with the selected services
-	filteredProject := *project
-	filteredProject.Services = filteredServices
+// envFileArgs builds the --env-file arguments for docker-compose
+func envFileArgs(envFiles []string) []string {
+	var args []string
+	for _, envFile := range envFiles {
+		args = append(args, "--env-file", envFile)
+	}
+	return args
+}
+
+// profileArgs builds the --profile arguments for docker-compose, skipping empty profile names
+func profileArgs(profiles []string) []string {
+	var args []string
+	for _, profile := range profiles {
+		if profile != "" {
+			args = append(args, "--profile", profile)
+		}
+	}
+	return args
+}
+
+// passthroughComposeArgs builds the docker-compose arguments following the -f options for
+// running a command without rewriting the project
+func passthroughComposeArgs(projectName, composeCmd string, cmdArgs commandArgs) []string {
+	args := append(projectNameArgs(projectName), projectDirectoryArgs(cmdArgs.projectDirectory)...)
+	args = append(args, envFileArgs(cmdArgs.envFiles)...)
+	args = append(args, profileArgs(cmdArgs.profiles)...)
+	args = append(args, composeCmd)
+	return append(args, cmdArgs.cmdOptions...)
+}
+
+// executePassthroughCommand runs docker-compose with all arguments passed through
+// without any service filtering. In dry-run mode the command line is printed instead
+func executePassthroughCommand(composePaths []string, args []string, dryRun, showCommand bool) error {
+	dockerComposeArgs := append(composeFileArgs(composePaths), args...)
+
+	if dryRun {
+		fmt.Println(shellQuoteArgs(dryRunCommandLine(dockerComposeArgs)))
+		return nil
+	}
+
+	cmd, err := newComposeCommand(dockerComposeArgs)
+	if err != nil {
+		return err
+	}
+
+	if showCommand {
+		fmt.Fprintf(os.Stderr, "+ %s\n", shellQuoteArgs(cmd.Args))
+	}
+	return runComposeCommand(cmd)
+}
+
+// shellQuoteArgs joins a command line into a string that a POSIX shell splits back into the
+// same arguments, quoting only the arguments that need it
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@%+", r))
+		}) {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// composeCommandLine returns the Docker Compose executable and its full argument list for
+// running Docker Compose with the given arguments
+func composeCommandLine(args []string) (string, []string, error) {
+	composeBin, composePrefix, err := resolveComposeCommand()
+	if err != nil {
+		return "", nil, err
+	}
+	return composeBin, append(append([]string{}, composePrefix...), args...), nil
+}
+
+// dryRunCommandLine returns the Docker Compose command line that would run with the given
+// arguments. Dry runs don't need Docker Compose to be installed, so when it can't be found
+// the command of the docker compose plugin is shown
+func dryRunCommandLine(args []string) []string {
+	composeBin, composeArgs, err := composeCommandLine(args)
+	if err != nil {
+		debugLog.Printf("Showing the docker compose plugin command: %v", err)
+		return append([]string{"docker", "compose"}, args...)
+	}
+	return append([]string{composeBin}, composeArgs...)
+}
+
+// newComposeCommand builds a Docker Compose command with the given arguments, attached to
+// quay's standard input and output
+func newComposeCommand(args []string) (*exec.Cmd, error) {
+	composeBin, composeArgs, err := composeCommandLine(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(composeBin, composeArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// runComposeCommand runs a docker-compose command and converts a non-zero exit status
+// into an exitCodeError so quay can exit with the same code
+func runComposeCommand(cmd *exec.Cmd) error {
+	debugLog.Printf("Running: %s", strings.Join(cmd.Args, " "))
+
+	restoreTerminal := startInProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		restoreTerminal()
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	done := make(chan struct{})
+	forwarding := make(chan struct{})
+	go func() {
+		defer close(forwarding)
+		forwardSignals(cmd.Process, signals, done)
+	}()
+
+	err := cmd.Wait()
+	signal.Stop(signals)
+	close(done)
+	<-forwarding
+	restoreTerminal()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			// Terminated by a signal, reported the way shells do
+			code = 128 + int(status.Signal())
+		} else if code < 0 {
+			code = 1
+		}
+		return &exitCodeError{code: code}
+	}
+
+	return err
+}
+
+// signalKillTimeout is how long docker-compose gets to shut down after the first forwarded
+// signal before it is killed. It is generous, as stopping containers takes a while and killing
+// docker-compose early leaves them half stopped. Tests shorten it
+var signalKillTimeout = time.Minute
+
+// forwardSignals relays signals received by quay to docker-compose until done is closed, and
+// kills docker-compose when it is still running signalKillTimeout after the first one. Later
+// signals are relayed as well, so a second Ctrl-C makes docker-compose stop without waiting
+func forwardSignals(process *os.Process, signals <-chan os.Signal, done <-chan struct{}) {
+	var killTimer <-chan time.Time
+	for {
+		select {
+		case sig := <-signals:
+			debugLog.Printf("Forwarding %s to docker-compose", sig)
+			_ = signalProcess(process, sig)
+			if killTimer == nil {
+				killTimer = time.After(signalKillTimeout)
+			}
+		case <-killTimer:
+			fmt.Fprintf(diagnostics, "Warning: docker-compose did not exit within %s of being signaled, killing it\n", signalKillTimeout)
+			_ = killProcess(process)
+		case <-done:
+			return
+		}
+	}
+}
+
+// executeFilteredCommand loads a Docker Compose project, filters it to only include the
+// specified services, and then runs docker-compose with those services.
+// When an output path is set, or in dry-run mode, the generated compose file is written out instead
+func executeFilteredCommand(composePaths []string, composeCmd string, cmdArgs commandArgs) error {
+	filteredProject, err := loadFilteredProject(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	if cmdArgs.output != "" || cmdArgs.dryRun {
+		rendered, err := renderProject(filteredProject, cmdArgs.format)
+		if err != nil {
+			return err
+		}
+
+		if cmdArgs.output != "" {
+			return writeProjectFile(cmdArgs.output, rendered)
+		}
+		if err := writeProjectFile("-", rendered); err != nil {
+			return err
+		}
+
+		// The printed command reads the compose file from stdin, so piping the output above
+		// into it reproduces the run
+		commandLine := dryRunCommandLine(filteredComposeArgs(filteredProject, stdinComposeFile, composeCmd, cmdArgs))
+		fmt.Fprintf(os.Stderr, "+ %s\n", shellQuoteArgs(commandLine))
+		return nil
+	}
+
+	yamlData, err := marshalProject(filteredProject)
+	if err != nil {
+		return err
+	}
+
+	// The configuration goes to a temporary file rather than stdin, so interactive commands
+	// can read from the terminal and the same file can be queried again after the command
+	composeFile, err := writeTempProjectFile(yamlData)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(composeFile)
+
+	// Ports published on host port 0 get assigned by Docker, so report them after a detached up
+	ephemeralPorts := ephemeralPortMappings(filteredProject, cmdArgs.portMappings)
+	reportPorts := composeCmd == "up" && isDetached(cmdArgs.cmdOptions) && len(ephemeralPorts) > 0
+
+	cmd, err := newComposeCommand(filteredComposeArgs(filteredProject, composeFile, composeCmd, cmdArgs))
+	if err != nil {
+		return err
+	}
+
+	if cmdArgs.showCommand {
+		fmt.Fprintf(os.Stderr, "+ %s\n", shellQuoteArgs(cmd.Args))
+		fmt.Fprintf(os.Stderr, "  (%s is the filtered compose file, generated for this run and removed afterwards; use -o to keep it)\n", composeFile)
+	}
+
+	if err := runComposeCommand(cmd); err != nil {
+		return err
+	}
+
+	if reportPorts {
+		return reportEphemeralPorts(filteredProjectArgs(filteredProject, composeFile), ephemeralPorts)
+	}
+
+	return nil
+}
+
+// filteredProjectArgs builds the global docker-compose arguments for running a filtered project
+// rendered to composeFile. docker-compose takes the directory of the compose file as project
+// directory, which would be the temporary directory here. compose-go makes most paths absolute,
+// but anything it leaves relative has to resolve against the directory of the original compose
+// file. The project name is passed explicitly for the same reason
+func filteredProjectArgs(project *types.Project, composeFile string) []string {
+	args := []string{"-f", composeFile, "--project-directory", project.WorkingDir}
+	args = append(args, projectNameArgs(project.Name)...)
+	return append(args, profileArgs(project.Profiles)...)
+}
+
+// filteredComposeArgs builds the docker-compose arguments for running a command on a filtered
+// project rendered to composeFile. Unless --no-remove-orphans is given, up also removes the
+// containers of filtered out services
+func filteredComposeArgs(project *types.Project, composeFile, composeCmd string, cmdArgs commandArgs) []string {
+	args := append(filteredProjectArgs(project, composeFile), composeCmd)
+	args = append(args, cmdArgs.cmdOptions...)
+
+	if composeCmd == "up" && !cmdArgs.noRemoveOrphans && !containsRemoveOrphans(cmdArgs.cmdOptions) {
+		args = append(args, "--remove-orphans")
+	}
+	return args
+}
+
+// ephemeralPortMappings returns the port mappings of services in the project that publish
+// on host port 0, leaving the choice of host port to Docker
+func ephemeralPortMappings(project *types.Project, portMappings []quay.PortMapping) []quay.PortMapping {
+	var ephemeral []quay.PortMapping
+	for _, mapping := range portMappings {
+		if _, exists := project.Services[mapping.ServiceName]; !exists {
+			continue
+		}
+		if hostPort, err := strconv.Atoi(mapping.HostPort); err == nil && hostPort == 0 {
+			ephemeral = append(ephemeral, mapping)
+		}
+	}
+	return ephemeral
+}
+
+// isDetached checks if the -d/--detach flag is present in the options list
+func isDetached(options []string) bool {
+	for _, opt := range options {
+		if opt == "-d" || opt == "--detach" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTempProjectFile writes a rendered compose file to a new temporary file and returns its
+// path. The caller is responsible for removing the file
+func writeTempProjectFile(data []byte) (string, error) {
+	file, err := os.CreateTemp("", "quay-*.yml")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary compose file: %w", err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("writing temporary compose file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("writing temporary compose file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// reportEphemeralPorts asks docker-compose which host ports were assigned to mappings
+// published on host port 0 and prints them as "service:port -> host_ip:host_port" lines
+func reportEphemeralPorts(composeArgs []string, mappings []quay.PortMapping) error {
+	for _, mapping := range mappings {
+		binding, err := queryPublishedPort(composeArgs, mapping.ServiceName, mapping.ContainerPort, mapping.Protocol, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		containerPort := mapping.ContainerPort
+		if mapping.Protocol != "tcp" {
+			containerPort += "/" + mapping.Protocol
+		}
+		fmt.Printf("%s:%s -> %s\n", mapping.ServiceName, containerPort, binding)
+	}
+
+	return nil
+}
+
+// queryPublishedPort asks docker-compose on which host address a container port of a running
+// service is published, returned as "host_ip:host_port". Errors of docker-compose go to stderr
+func queryPublishedPort(composeArgs []string, serviceName, containerPort, protocol string, stderr io.Writer) (string, error) {
+	portArgs := append(append([]string{}, composeArgs...), "port", "--protocol", protocol, serviceName, containerPort)
+
+	cmd, err := newComposeCommand(portArgs)
+	if err != nil {
+		return "", err
+	}
+	cmd.Stdout = nil
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("querying published port of %s:%s: %w", serviceName, containerPort, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// loadFilteredProject loads a Docker Compose project, merging all compose files in order,
+// filters it to only include the specified services and applies the requested overrides.
+// Warnings about the filtering are reported on stderr
+func loadFilteredProject(composePaths []string, cmdArgs commandArgs) (*types.Project, error) {
+	_, filteredProject, err := loadProjects(composePaths, cmdArgs)
+	return filteredProject, err
+}
+
+// normalizeProjectName turns a project name into one Docker Compose accepts, the way it
+// turns directory names into project names: lowercased, with everything but letters, digits,
+// dashes and underscores removed and without leading dashes and underscores
+func normalizeProjectName(name string) (string, error) {
+	normalized := loader.NormalizeProjectName(name)
+	if normalized == "" {
+		return "", fmt.Errorf("invalid project name '%s': must contain a letter or digit", name)
+	}
+	if normalized != name {
+		debugLog.Printf("Using project name %s for %s", normalized, name)
+	}
+	return normalized, nil
+}
+
+// withNormalizedProjectName sets the project name to the given name, or otherwise to
+// COMPOSE_PROJECT_NAME, normalized, where compose-go would reject names that are not. It has
+// to follow the options that read the environment
+func withNormalizedProjectName(name string) cli.ProjectOptionsFn {
+	return func(o *cli.ProjectOptions) error {
+		if name == "" {
+			name = o.Environment[consts.ComposeProjectName]
+		}
+		if name == "" {
+			return nil
+		}
+
+		normalized, err := normalizeProjectName(name)
+		if err != nil {
+			return err
+		}
+		o.Name = normalized
+		return nil
+	}
+}
+
+// resolveProjectName returns the name compose-go gives the project: the -p option, then
+// COMPOSE_PROJECT_NAME, the top-level name of the compose files and the name of the project
+// directory. Only the name matters, so the project is loaded without validating it
+func resolveProjectName(composePaths []string, cmdArgs commandArgs) (string, error) {
+	if cmdArgs.projectName != "" {
+		return normalizeProjectName(cmdArgs.projectName)
+	}
+
+	projectOptions, err := cli.NewProjectOptions(
+		composePaths,
+		cli.WithWorkingDirectory(cmdArgs.projectDirectory),
+		cli.WithOsEnv,
+		cli.WithEnvFiles(cmdArgs.envFiles...),
+		cli.WithDotEnv,
+		withNormalizedProjectName(""),
+		cli.WithConsistency(false),
+		cli.WithLoadOptions(func(options *loader.Options) {
+			options.SkipValidation = true
+		}),
+	)
+	if err != nil {
+		return "", fmt.Errorf("creating project options: %w", err)
+	}
+
+	project, err := projectOptions.LoadProject(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("loading project: %w", err)
+	}
+	return project.Name, nil
+}
+
+// loadProjects works like loadFilteredProject, but also returns the project as loaded, with
+// the profiles of included services enabled, before any filtering or overrides
+func loadProjects(composePaths []string, cmdArgs commandArgs) (*types.Project, *types.Project, error) {
+	ctx := context.Background()
+
+	projectOptions, err := cli.NewProjectOptions(
+		composePaths,
+		cli.WithWorkingDirectory(cmdArgs.projectDirectory),
+		cli.WithOsEnv,
+		cli.WithEnvFiles(cmdArgs.envFiles...),
+		cli.WithDotEnv,
+		withNormalizedProjectName(cmdArgs.projectName),
+		cli.WithDefaultProfiles(cmdArgs.profiles...),
+		cli.WithConsistency(!cmdArgs.skipConsistencyCheck),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating project options: %w", err)
+	}
+
+	project, err := projectOptions.LoadProject(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading project: %w", err)
+	}
+
+	if err := checkGroupServices(project, cmdArgs.groupServices); err != nil {
+		return nil, nil, err
+	}
+
+	debugLog.Printf("Loaded services: %s", strings.Join(project.ServiceNames(), ", "))
+	debugLog.Printf("Filter: include=%v exclude=%v include-re=%v exclude-re=%v include-label=%v exclude-label=%v select=%v",
+		cmdArgs.IncludeServices, cmdArgs.ExcludeServices, cmdArgs.IncludeRegexps, cmdArgs.ExcludeRegexps,
+		cmdArgs.IncludeLabels, cmdArgs.ExcludeLabels, cmdArgs.Selectors)
+
+	project, enabledProfiles, err := enableProfilesForServices(project, cmdArgs.IncludeServices)
+	if err != nil {
+		return nil, nil, fmt.Errorf("enabling profiles: %w", err)
+	}
+
+	for _, notice := range enabledProfiles {
+		fmt.Fprintln(diagnostics, notice)
+	}
+
+	selection, err := quay.SelectServices(project, cmdArgs.FilterOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	filteredProject, missingServices := selection.Project, selection.Missing
+	debugLog.Printf("Selected services: %s", strings.Join(filteredProject.ServiceNames(), ", "))
+
+	if len(selection.ContainerNames) > 0 {
+		fmt.Fprintln(diagnostics, "Matched services by container_name:")
+		for _, match := range selection.ContainerNames {
+			fmt.Fprintf(diagnostics, "  - %s\n", match)
+		}
+	}
+
+	if len(selection.Dependents) > 0 {
+		fmt.Fprintln(diagnostics, "Including dependents of the selected services:")
+		for _, name := range selection.Dependents {
+			fmt.Fprintf(diagnostics, "  - %s\n", name)
+		}
+	}
+
+	if len(selection.Dependencies) > 0 {
+		fmt.Fprintln(diagnostics, "Including dependencies of the selected services:")
+		for _, name := range selection.Dependencies {
+			fmt.Fprintf(diagnostics, "  - %s\n", name)
+		}
+	}
+
+	if len(selection.Required) > 0 {
+		fmt.Fprintln(diagnostics, "Keeping services required by the selected services:")
+		for _, name := range selection.Required {
+			fmt.Fprintf(diagnostics, "  - %s\n", name)
+		}
+	}
+
+	// Dependencies on filtered out services are dropped unless asked to keep them or fail
+	if cmdArgs.excludeDepsMode != excludeDepsKeep {
+		droppedDependencies := quay.RemoveDanglingDependencies(filteredProject)
+		if len(droppedDependencies) > 0 && cmdArgs.excludeDepsMode == excludeDepsError {
+			return nil, nil, fmt.Errorf("services depend on services that are not part of the filtered project: %s", strings.Join(droppedDependencies, ", "))
+		}
+		if len(droppedDependencies) > 0 {
+			fmt.Fprintln(diagnostics, "Warning: Dropped dependencies on services that are not part of the filtered project:")
+			for _, edge := range droppedDependencies {
+				fmt.Fprintf(diagnostics, "  - %s\n", edge)
+			}
+		}
+	}
+
+	pruneUnusedResources(filteredProject, cmdArgs.pruneUnused)
+
+	filteredProject, missingOverrideServices, err := applyOverrideFiles(filteredProject, cmdArgs.overrideFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingServices = append(missingServices, missingOverrideServices...)
+
+	// Default port mappings from the settings file go first, so --port overrides them
+	if _, err := quay.ApplyPortMappings(filteredProject, cmdArgs.defaultPortMappings, nil); err != nil {
+		return nil, nil, err
+	}
+
+	templatedMappings, err := expandPortTemplates(cmdArgs.portTemplates, project.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	portMappings := append(append([]quay.PortMapping{}, cmdArgs.portMappings...), templatedMappings...)
+
+	// Apply port mappings to filtered project
+	missingPortServices, err := quay.ApplyPortMappings(filteredProject, portMappings, cmdArgs.noPorts)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingServices = append(missingServices, missingPortServices...)
+
+	if err := applyPortOffset(filteredProject, cmdArgs.portOffset); err != nil {
+		return nil, nil, err
+	}
+
+	if conflicts := portConflicts(filteredProject); len(conflicts) > 0 {
+		if !cmdArgs.force {
+			return nil, nil, fmt.Errorf("conflicting published ports: %s (use --force to continue anyway)", strings.Join(conflicts, "; "))
+		}
+		fmt.Fprintln(diagnostics, "Warning: Conflicting published ports:")
+		for _, conflict := range conflicts {
+			fmt.Fprintf(diagnostics, "  - %s\n", conflict)
+		}
+	}
+
+	if cmdArgs.checkPorts {
+		for _, warning := range probeHostPorts(filteredProject, listenOnHost) {
+			fmt.Fprintf(diagnostics, "Warning: %s\n", warning)
+		}
+	}
+
+	missingEnvServices := applyEnvOverrides(filteredProject, cmdArgs.envOverrides)
+	missingServices = append(missingServices, missingEnvServices...)
+
+	missingUnsetServices, absentVariables := applyEnvUnsets(filteredProject, cmdArgs.envUnsets)
+	missingServices = append(missingServices, missingUnsetServices...)
+	for _, unset := range absentVariables {
+		fmt.Fprintf(diagnostics, "Warning: Environment variable %s is not set on service %s, nothing to unset\n", unset.Key, unset.ServiceName)
+	}
+
+	applyRegistry(filteredProject, cmdArgs.registry, cmdArgs.replaceRegistry)
+
+	missingImageServices := applyImageOverrides(filteredProject, cmdArgs.images, cmdArgs.keepBuild)
+	missingServices = append(missingServices, missingImageServices...)
+
+	missingCommandServices := applyCommandOverrides(filteredProject, cmdArgs.commands, cmdArgs.entrypoints)
+	missingServices = append(missingServices, missingCommandServices...)
+
+	missingVolumeServices := applyVolumeMounts(filteredProject, cmdArgs.volumes)
+	missingServices = append(missingServices, missingVolumeServices...)
+
+	missingScaleServices, err := applyScales(filteredProject, cmdArgs.scales)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingServices = append(missingServices, missingScaleServices...)
+
+	sort.Strings(missingServices)
+
+	if len(missingServices) > 0 && cmdArgs.strict {
+		var descriptions []string
+		for _, name := range missingServices {
+			descriptions = append(descriptions, describeMissingService(name, project))
+		}
+		return nil, nil, fmt.Errorf("services not found in the docker-compose file: %s (available services: %s)",
+			strings.Join(descriptions, ", "), strings.Join(project.ServiceNames(), ", "))
+	}
+
+	if len(missingServices) > 0 {
+		fmt.Fprintln(diagnostics, "Warning: Some requested services were not found in the docker-compose file:")
+		for _, name := range missingServices {
+			fmt.Fprintf(diagnostics, "  - %s\n", describeMissingService(name, project))
+		}
+
+		for _, hint := range profileHints(project, missingServices) {
+			fmt.Fprintf(diagnostics, "Hint: %s\n", hint)
+		}
+	}
+
+	return project, filteredProject, nil
+}
+
+// meaningfulEmptyKeys lists keys whose empty value differs from leaving them out, such as
+// "command: []" clearing the command of the image, so marshalProject keeps them
+var meaningfulEmptyKeys = map[string]bool{
+	"command":    true,
+	"entrypoint": true,
+	"test":       true,
+}
+
+// marshalProject renders a project as a compose file. Empty mappings and sequences are left
+// out so the result stays close to what was written and is easy to diff. Null values are kept,
+// since compose gives them a meaning, like attaching to a network or unsetting a variable
+func marshalProject(project *types.Project) ([]byte, error) {
+	var document yaml.Node
+	if err := document.Encode(project); err != nil {
+		return nil, fmt.Errorf("marshaling filtered project: %w", err)
+	}
+
+	pruneEmptyNodes(&document)
+
+	yamlData, err := yaml.Marshal(&document)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling filtered project: %w", err)
+	}
+	return yamlData, nil
+}
+
+// renderProject renders a project for output in the given format. JSON uses compose-go's own
+// encoding, in which ports, durations and sizes take the forms Docker Compose accepts
+func renderProject(project *types.Project, format string) ([]byte, error) {
+	if format != formatJSON {
+		return marshalProject(project)
+	}
+
+	jsonData, err := json.MarshalIndent(project, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling filtered project: %w", err)
+	}
+	return append(jsonData, '\n'), nil
+}
+
+// pruneEmptyNodes removes mapping entries with an empty mapping or sequence as value, after
+// pruning the value itself, except for the keys in meaningfulEmptyKeys
+func pruneEmptyNodes(node *yaml.Node) {
+	for _, child := range node.Content {
+		pruneEmptyNodes(child)
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	var content []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		isEmpty := (value.Kind == yaml.MappingNode || value.Kind == yaml.SequenceNode) && len(value.Content) == 0
+		if isEmpty && !meaningfulEmptyKeys[key.Value] {
+			continue
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+}
+
+// writeProjectFile writes a rendered compose file to the given path, or to stdout when the
+// path is "-", creating missing parent directories
+func writeProjectFile(outputPath string, data []byte) error {
+	if outputPath == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	if err := os.WriteFile(outputPath, data, 0o644); err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+
+	return nil
+}
+
+// applyPortOffset shifts every published host port in the project by offset, including both
+// ends of port ranges. Ports left for Docker to assign are not shifted
+func applyPortOffset(project *types.Project, offset int) error {
+	if offset == 0 {
+		return nil
+	}
+
+	for name, service := range project.Services {
+		if len(service.Ports) == 0 {
+			continue
+		}
+
+		// The ports slice may be shared with the unfiltered project, so work on a copy
+		service.Ports = append([]types.ServicePortConfig(nil), service.Ports...)
+		for i, port := range service.Ports {
+			if port.Published == "" {
+				continue
+			}
+
+			start, end, isRange := strings.Cut(port.Published, "-")
+			shifted, err := shiftPort(start, offset)
+			if err != nil {
+				return fmt.Errorf("applying port offset to %s: %w", name, err)
+			}
+			if isRange {
+				shiftedEnd, err := shiftPort(end, offset)
+				if err != nil {
+					return fmt.Errorf("applying port offset to %s: %w", name, err)
+				}
+				shifted += "-" + shiftedEnd
+			}
+			service.Ports[i].Published = shifted
+		}
+
+		project.Services[name] = service
+	}
+
+	return nil
+}
+
+// shiftPort adds offset to a host port, keeping port 0 as is since Docker assigns it
+func shiftPort(value string, offset int) (string, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid published port '%s'", value)
+	}
+	if port == 0 {
+		return value, nil
+	}
+
+	shifted := port + offset
+	if shifted < 1 || shifted > 65535 {
+		return "", fmt.Errorf("port %d shifted by %d is out of range 1-65535", port, offset)
+	}
+	return strconv.Itoa(shifted), nil
+}
+
+// hostPort identifies a port published on the host
+type hostPort struct {
+	ip       string
+	port     int
+	protocol string
+}
+
+// String formats the host port the way compose writes published ports
+func (p hostPort) String() string {
+	address := strconv.Itoa(p.port)
+	if p.ip != "" {
+		address = net.JoinHostPort(p.ip, address)
+	}
+	return address + "/" + p.protocol
+}
+
+// publishedHostPorts returns the host ports a service publishes, expanding port ranges.
+// Ports left for Docker to assign are skipped
+func publishedHostPorts(service types.ServiceConfig) []hostPort {
+	var ports []hostPort
+	for _, port := range service.Ports {
+		start, end, isRange := strings.Cut(port.Published, "-")
+		if !isRange {
+			end = start
+		}
+
+		first, err := strconv.Atoi(start)
+		if err != nil || first == 0 {
+			continue
+		}
+		last, err := strconv.Atoi(end)
+		if err != nil {
+			continue
+		}
+
+		for number := first; number <= last; number++ {
+			ports = append(ports, hostPort{ip: port.HostIP, port: number, protocol: quay.PortProtocol(port)})
+		}
+	}
+	return ports
+}
+
+// portConflicts returns a description of every host port that more than one service publishes.
+// A port published on all interfaces conflicts with the same port on any specific address
+func portConflicts(project *types.Project) []string {
+	owners := make(map[hostPort]string)
+	var conflicts []string
+
+	for _, name := range project.ServiceNames() {
+		for _, port := range publishedHostPorts(project.Services[name]) {
+			candidates := []hostPort{port}
+			if port.ip == "" {
+				for other := range owners {
+					if other.ip != "" && other.port == port.port && other.protocol == port.protocol {
+						candidates = append(candidates, other)
+					}
+				}
+			} else {
+				candidates = append(candidates, hostPort{port: port.port, protocol: port.protocol})
+			}
+
+			for _, candidate := range candidates {
+				if owner, taken := owners[candidate]; taken && owner != name {
+					conflicts = append(conflicts, fmt.Sprintf("%s and %s both publish %s", owner, name, port))
+					break
+				}
+			}
+			owners[port] = name
+		}
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// listenOnHost checks whether a host port is free by briefly binding it
+func listenOnHost(port hostPort) error {
+	address := net.JoinHostPort(port.ip, strconv.Itoa(port.port))
+	if port.protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// probeHostPorts tries every published host port with the given listen function and returns
+// a warning for each port that is already in use outside the stack
+func probeHostPorts(project *types.Project, listen func(hostPort) error) []string {
+	var warnings []string
+	for _, name := range project.ServiceNames() {
+		for _, port := range publishedHostPorts(project.Services[name]) {
+			if err := listen(port); err != nil {
+				warnings = append(warnings, fmt.Sprintf("host port %s of service %s is not available: %v", port, name, err))
+			}
+		}
+	}
+	return warnings
+}
+
+// applyEnvOverrides sets environment variables on services in the filtered project, later
+// overrides winning over earlier ones, and returns a list of services that were requested but not found
+func applyEnvOverrides(project *types.Project, overrides []EnvOverride) []string {
+	var missingServices []string
+
+	for _, override := range overrides {
+		if override.ServiceName == "" {
+			for name, service := range project.Services {
+				project.Services[name] = setServiceEnv(service, override.Key, override.Value)
+			}
+			continue
+		}
+
+		service, exists := project.Services[override.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, override.ServiceName)
+			continue
+		}
+		project.Services[override.ServiceName] = setServiceEnv(service, override.Key, override.Value)
+	}
+
+	return missingServices
+}
+
+// setServiceEnv returns the service with an environment variable set. The environment is
+// shared with the unfiltered project, so a copy is updated
+func setServiceEnv(service types.ServiceConfig, key, value string) types.ServiceConfig {
+	environment := make(types.MappingWithEquals, len(service.Environment)+1)
+	for name, existing := range service.Environment {
+		environment[name] = existing
+	}
+	environment[key] = &value
+	service.Environment = environment
+	return service
+}
+
+// applyEnvUnsets removes environment variables from services in the filtered project. It returns
+// a list of services that were requested but not found, and the unsets of variables that were
+// not set at all. Variables of services with env_file are kept with a null value instead, since
+// docker-compose would otherwise read them from the env file again
+func applyEnvUnsets(project *types.Project, unsets []EnvUnset) ([]string, []EnvUnset) {
+	var missingServices []string
+	var absentVariables []EnvUnset
+
+	for _, unset := range unsets {
+		service, exists := project.Services[unset.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, unset.ServiceName)
+			continue
+		}
+
+		if value, set := service.Environment[unset.Key]; !set || value == nil {
+			absentVariables = append(absentVariables, unset)
+			continue
+		}
+
+		// The environment is shared with the unfiltered project, so update a copy
+		environment := make(types.MappingWithEquals, len(service.Environment))
+		for key, value := range service.Environment {
+			environment[key] = value
+		}
+		if len(service.EnvFiles) > 0 {
+			environment[unset.Key] = nil
+		} else {
+			delete(environment, unset.Key)
+		}
+		service.Environment = environment
+
+		project.Services[unset.ServiceName] = service
+	}
+
+	return missingServices, absentVariables
+}
+
+// applyImageOverrides replaces the image of services in the filtered project and returns a list
+// of services that were requested but not found. Unless keepBuild is set, the build section is
+// dropped so docker-compose runs the given image rather than building over it
+func applyImageOverrides(project *types.Project, images []ImageOverride, keepBuild bool) []string {
+	var missingServices []string
+
+	for _, image := range images {
+		service, exists := project.Services[image.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, image.ServiceName)
+			continue
+		}
+
+		service.Image = image.Image
+		if !keepBuild {
+			service.Build = nil
+			// Without a build section there is nothing to build, so pull the image instead
+			if service.PullPolicy == types.PullPolicyBuild {
+				service.PullPolicy = ""
+			}
+		}
+
+		project.Services[image.ServiceName] = service
+	}
+
+	return missingServices
+}
+
+// applyRegistry prefixes the image of every service in the project with a registry path.
+// With replace set, a registry named in the image reference is swapped for the prefix instead
+// of being kept after it. Services without an image, which are only built, are left alone
+func applyRegistry(project *types.Project, prefix string, replace bool) {
+	if prefix == "" {
+		return
+	}
+
+	for name, service := range project.Services {
+		if service.Image == "" {
+			continue
+		}
+
+		image := service.Image
+		if replace {
+			_, image = splitImageRegistry(image)
+		}
+		service.Image = prefix + "/" + image
+
+		project.Services[name] = service
+	}
+}
+
+// splitImageRegistry splits an image reference into its registry host and the remainder as
+// written. The registry is found the way Docker finds it, so "postgres:16" and "foo/bar" have
+// none while "localhost:5000/foo" has one. References Docker cannot parse are not split
+func splitImageRegistry(image string) (string, string) {
+	registry, remainder, found := strings.Cut(image, "/")
+	if !found {
+		return "", image
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", image
+	}
+
+	// Docker Hub references are normalized to docker.io, even when written as index.docker.io
+	domain := reference.Domain(named)
+	if registry != domain && (domain != "docker.io" || registry != "index.docker.io") {
+		return "", image
+	}
+	return registry, remainder
+}
+
+// applyCommandOverrides replaces the command and entrypoint of services in the filtered project
+// and returns a list of services that were requested but not found. An override without
+// arguments is rendered as an empty list, which clears the default of the image too
+func applyCommandOverrides(project *types.Project, commands, entrypoints []CommandOverride) []string {
+	var missingServices []string
+
+	for _, override := range commands {
+		service, exists := project.Services[override.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, override.ServiceName)
+			continue
+		}
+		service.Command = override.Args
+		project.Services[override.ServiceName] = service
+	}
+
+	for _, override := range entrypoints {
+		service, exists := project.Services[override.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, override.ServiceName)
+			continue
+		}
+		service.Entrypoint = override.Args
+		project.Services[override.ServiceName] = service
+	}
+
+	return missingServices
+}
+
+// applyVolumeMounts adds bind mounts to services in the filtered project and returns a list of
+// services that were requested but not found. Relative host paths are resolved against the
+// project directory, since docker-compose reads the rendered file from elsewhere. A mount
+// replaces any existing volume at the same container path
+func applyVolumeMounts(project *types.Project, volumes []VolumeMount) []string {
+	var missingServices []string
+
+	for _, volume := range volumes {
+		service, exists := project.Services[volume.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, volume.ServiceName)
+			continue
+		}
+
+		hostPath := volume.HostPath
+		if !filepath.IsAbs(hostPath) {
+			hostPath = filepath.Join(project.WorkingDir, hostPath)
+		}
+
+		// The volumes slice is shared with the unfiltered project, so build a new one
+		var serviceVolumes []types.ServiceVolumeConfig
+		for _, existing := range service.Volumes {
+			if existing.Target != volume.ContainerPath {
+				serviceVolumes = append(serviceVolumes, existing)
+			}
+		}
+		service.Volumes = append(serviceVolumes, types.ServiceVolumeConfig{
+			Type:     types.VolumeTypeBind,
+			Source:   hostPath,
+			Target:   volume.ContainerPath,
+			ReadOnly: volume.ReadOnly,
+		})
+
+		project.Services[volume.ServiceName] = service
+	}
+
+	return missingServices
+}
+
+// applyScales sets the number of containers for services in the filtered project through
+// deploy.replicas and returns a list of services that were requested but not found.
+// A count of 0 keeps the service defined without starting it. Services with a fixed
+// container_name can only run a single container, so scaling them is an error
+func applyScales(project *types.Project, scales []ServiceScale) ([]string, error) {
+	var missingServices []string
+
+	for _, scale := range scales {
+		service, exists := project.Services[scale.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, scale.ServiceName)
+			continue
+		}
+
+		if service.ContainerName != "" && scale.Replicas > 1 {
+			return nil, fmt.Errorf("cannot scale service %s to %d: it sets container_name %s", scale.ServiceName, scale.Replicas, service.ContainerName)
+		}
+
+		// The deploy config is shared with the unfiltered project, so update a copy
+		replicas := scale.Replicas
+		var deploy types.DeployConfig
+		if service.Deploy != nil {
+			deploy = *service.Deploy
+		}
+		deploy.Replicas = &replicas
+		service.Deploy = &deploy
+
+		// Compose rejects a scale that disagrees with deploy.replicas, so keep both in sync
+		if service.Scale != nil {
+			service.Scale = &replicas
+		}
+
+		project.Services[scale.ServiceName] = service
+	}
+
+	return missingServices, nil
+}
+
+// pruneUnusedResources removes top-level secrets and configs that no service in the project
+// references anymore, and unused networks and volumes as well when pruneNetworksAndVolumes is
+// set. External resources are always kept since compose only validates them when they are used
+func pruneUnusedResources(project *types.Project, pruneNetworksAndVolumes bool) {
+	usedNetworks := make(map[string]bool)
+	usedVolumes := make(map[string]bool)
+	usedSecrets := make(map[string]bool)
+	usedConfigs := make(map[string]bool)
+
+	for _, service := range project.Services {
+		for name := range service.Networks {
+			usedNetworks[name] = true
+		}
+		for _, volume := range service.Volumes {
+			if volume.Type == types.VolumeTypeVolume {
+				usedVolumes[volume.Source] = true
+			}
+		}
+		for _, secret := range service.Secrets {
+			usedSecrets[secret.Source] = true
+		}
+		if service.Build != nil {
+			for _, secret := range service.Build.Secrets {
+				usedSecrets[secret.Source] = true
+			}
+		}
+		for _, config := range service.Configs {
+			usedConfigs[config.Source] = true
+		}
+	}
+
+	// The maps are shared with the unfiltered project, so build new ones rather than delete
+	secrets := types.Secrets{}
+	for name, secret := range project.Secrets {
+		if usedSecrets[name] || bool(secret.External) {
+			secrets[name] = secret
+		}
+	}
+	project.Secrets = secrets
+
+	configs := types.Configs{}
+	for name, config := range project.Configs {
+		if usedConfigs[name] || bool(config.External) {
+			configs[name] = config
+		}
+	}
+	project.Configs = configs
+
+	if !pruneNetworksAndVolumes {
+		return
+	}
+
+	networks := types.Networks{}
+	for name, network := range project.Networks {
+		if usedNetworks[name] || bool(network.External) {
+			networks[name] = network
+		}
+	}
+	project.Networks = networks
+
+	volumes := types.Volumes{}
+	for name, volume := range project.Volumes {
+		if usedVolumes[name] || bool(volume.External) {
+			volumes[name] = volume
+		}
+	}
+	project.Volumes = volumes
+}
+
+// enableProfilesForServices activates a profile for every explicitly included service that is
+// disabled because none of its profiles is active, and returns the updated project along with
+// a notice per enabled profile. Other services of those profiles are still subject to filtering
+func enableProfilesForServices(project *types.Project, includeServices []string) (*types.Project, []string, error) {
+	profiles := append([]string{}, project.Profiles...)
+
+	var notices []string
+	for _, name := range includeServices {
+		service, disabled := project.DisabledServices[name]
+		if !disabled || len(service.Profiles) == 0 {
+			continue
+		}
+
+		profiles = append(profiles, service.Profiles[0])
+		notices = append(notices, fmt.Sprintf("Enabling profile '%s' for service %s", service.Profiles[0], name))
+	}
+
+	if len(notices) == 0 {
+		return project, nil, nil
+	}
+
+	project, err := project.WithProfiles(profiles)
+	return project, notices, err
+}
+
+// profileHints explains which missing services exist in the project but are disabled
+// because none of their profiles is active
+func profileHints(project *types.Project, missingServices []string) []string {
+	var hints []string
+	for _, name := range missingServices {
+		service, disabled := project.DisabledServices[name]
+		if !disabled {
+			continue
+		}
+		hints = append(hints, fmt.Sprintf("service %s is only enabled with profile %s (use --profile %s)",
+			name, strings.Join(service.Profiles, " or "), service.Profiles[0]))
+	}
+	return hints
+}
+
+// maxSuggestionDistance is the largest edit distance at which a service name is suggested
+// as a replacement for an unknown one
+const maxSuggestionDistance = 2
+
+// describeMissingService formats an unknown service name for error reporting, telling apart
+// services that are filtered out or inactive under the current profiles from unknown ones,
+// and adding the closest existing service name as a suggestion when there is one
+func describeMissingService(name string, project *types.Project) string {
+	if _, exists := project.Services[name]; exists {
+		return fmt.Sprintf("%s (not part of the filtered services)", name)
+	}
+
+	if _, disabled := project.DisabledServices[name]; disabled {
+		return fmt.Sprintf("%s (inactive under the current profiles)", name)
+	}
+
+	if suggestion := suggestService(name, project); suggestion != "" {
+		return fmt.Sprintf("%s (did you mean '%s'?)", name, suggestion)
+	}
+	return name
+}
+
+// suggestService returns the service name closest to the given unknown name, or an empty
+// string when none is within maxSuggestionDistance edits. Glob patterns get no suggestion
+func suggestService(name string, project *types.Project) string {
+	if quay.IsGlobPattern(name) {
+		return ""
+	}
+
+	suggestion := ""
+	bestDistance := maxSuggestionDistance + 1
+	for _, candidate := range project.ServiceNames() {
+		// Replacing most of a short name is not a typo, so require part of it to match
+		if distance := editDistance(name, candidate); distance < bestDistance && distance < len(name) {
+			suggestion = candidate
+			bestDistance = distance
+		}
+	}
+	return suggestion
+}
+
+// editDistance computes the Damerau-Levenshtein distance (optimal string alignment variant)
+// between two strings, so a swap of adjacent characters counts as a single edit
+func editDistance(a, b string) int {
+	runesA, runesB := []rune(a), []rune(b)
+
+	distances := make([][]int, len(runesA)+1)
+	for i := range distances {
+		distances[i] = make([]int, len(runesB)+1)
+		distances[i][0] = i
+	}
+	for j := range distances[0] {
+		distances[0][j] = j
+	}
+
+	for i := 1; i <= len(runesA); i++ {
+		for j := 1; j <= len(runesB); j++ {
+			cost := 1
+			if runesA[i-1] == runesB[j-1] {
+				cost = 0
+			}
+			distances[i][j] = min(distances[i-1][j]+1, distances[i][j-1]+1, distances[i-1][j-1]+cost)
+			if i > 1 && j > 1 && runesA[i-1] == runesB[j-2] && runesA[i-2] == runesB[j-1] {
+				distances[i][j] = min(distances[i][j], distances[i-2][j-2]+1)
+			}
+		}
+	}
 
-	return &filteredProject, missingServices
+	return distances[len(runesA)][len(runesB)]
 }
 
-// containsRemoveOrphans checks if the --remove-orphans flag is present in the options list
+// containsRemoveOrphans checks if the --remove-orphans flag is present in the options list,
+// with or without a value
 func containsRemoveOrphans(options []string) bool {
 	for _, opt := range options {
-		if opt == "--remove-orphans" {
+		if opt == "--remove-orphans" || strings.HasPrefix(opt, "--remove-orphans=") {
 			return true
 		}
 	}
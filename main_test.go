@@ -0,0 +1,1737 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/yarlson/quay/pkg/quay"
+)
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeComposeFile writes a compose file to dir and returns its path
+func writeComposeFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	composePath := filepath.Join(dir, "compose.yaml")
+	if err := os.WriteFile(composePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return composePath
+}
+
+func TestPassthroughComposeArgsProjectName(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	dir := filepath.Join(t.TempDir(), "shop")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	composePath := writeComposeFile(t, dir, "services:\n  web:\n    image: nginx\n")
+	envFile := filepath.Join(dir, "a.env")
+	if err := os.WriteFile(envFile, []byte("TAG=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		options []string
+		cmdArgs commandArgs
+		want    []string
+	}{
+		{
+			name: "directory name",
+			want: []string{"-p", "shop", "ps"},
+		},
+		{
+			name:    "explicit name",
+			cmdArgs: commandArgs{projectName: "billing"},
+			want:    []string{"-p", "billing", "ps"},
+		},
+		{
+			name:    "everything",
+			options: []string{"--all"},
+			cmdArgs: commandArgs{projectDirectory: dir, envFiles: []string{envFile}, profiles: []string{"debug"}},
+			want:    []string{"-p", "shop", "--project-directory", dir, "--env-file", envFile, "--profile", "debug", "ps", "--all"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cmdArgs.cmdOptions = tt.options
+			projectName, err := resolveProjectName([]string{composePath}, tt.cmdArgs)
+			if err != nil {
+				t.Fatalf("resolveProjectName: %v", err)
+			}
+			if got := passthroughComposeArgs(projectName, "ps", tt.cmdArgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilteredComposeArgsProjectName(t *testing.T) {
+	project := &types.Project{Name: "shop", WorkingDir: "/srv/shop", Profiles: []string{"debug"}}
+	want := []string{"-f", "/tmp/quay.yml", "--project-directory", "/srv/shop", "-p", "shop", "--profile", "debug", "ps"}
+	if got := filteredComposeArgs(project, "/tmp/quay.yml", "ps", commandArgs{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestProjectNamePrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     string
+		env      string
+		dotEnv   string
+		topLevel string
+		want     string
+		wantErr  bool
+	}{
+		{name: "flag wins", flag: "cli", env: "fromenv", dotEnv: "fromdotenv", topLevel: "fromfile", want: "cli"},
+		{name: "environment before the compose file", env: "fromenv", dotEnv: "fromdotenv", topLevel: "fromfile", want: "fromenv"},
+		{name: ".env before the compose file", dotEnv: "fromdotenv", topLevel: "fromfile", want: "fromdotenv"},
+		{name: "compose file before the directory", topLevel: "fromfile", want: "fromfile"},
+		{name: "directory", want: "my_shop"},
+		{name: "flag is normalized", flag: "My.Shop", want: "myshop"},
+		{name: "environment is normalized", env: "Foo_Bar", topLevel: "fromfile", want: "foo_bar"},
+		{name: "compose file is normalized", topLevel: "Shop Front", want: "shopfront"},
+		{name: "leading dashes and underscores are dropped", env: "__-web", want: "web"},
+		{name: "nothing left of the flag", flag: "_-_", wantErr: true},
+		{name: "nothing left of the environment", env: "???", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An empty but set variable would still hide the .env file
+			t.Setenv("COMPOSE_PROJECT_NAME", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("COMPOSE_PROJECT_NAME")
+			}
+			dir := filepath.Join(t.TempDir(), "My_Shop")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			content := "services:\n  web:\n    image: nginx\n"
+			if tt.topLevel != "" {
+				content = "name: " + tt.topLevel + "\n" + content
+			}
+			composePath := writeComposeFile(t, dir, content)
+			if tt.dotEnv != "" {
+				if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("COMPOSE_PROJECT_NAME="+tt.dotEnv+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmdArgs := commandArgs{projectName: tt.flag}
+			resolved, err := resolveProjectName([]string{composePath}, cmdArgs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveProjectName = %q, want an error", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveProjectName: %v", err)
+			}
+			if resolved != tt.want {
+				t.Errorf("passthrough name = %q, want %q", resolved, tt.want)
+			}
+
+			// Filtered runs have to end up with the same name
+			cmdArgs.IncludeServices = []string{"web"}
+			_, filteredProject, err := loadProjects([]string{composePath}, cmdArgs)
+			if err != nil {
+				t.Fatalf("loadProjects: %v", err)
+			}
+			if filteredProject.Name != tt.want {
+				t.Errorf("filtered name = %q, want %q", filteredProject.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilteredDownOptions(t *testing.T) {
+	project := &types.Project{Name: "shop", WorkingDir: "/srv/shop"}
+	prefix := []string{"-f", "/tmp/quay.yml", "--project-directory", "/srv/shop", "-p", "shop"}
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    []string
+	}{
+		{
+			name:    "down -v",
+			command: "down",
+			args:    []string{"--include", "web", "-v"},
+			want:    []string{"down", "-v"},
+		},
+		{
+			name:    "down --rmi local",
+			command: "down",
+			args:    []string{"--rmi", "local", "--include", "web"},
+			want:    []string{"down", "--rmi", "local"},
+		},
+		{
+			name:    "down --remove-orphans",
+			command: "down",
+			args:    []string{"--include", "web", "--remove-orphans"},
+			want:    []string{"down", "--remove-orphans"},
+		},
+		{
+			name:    "down does not remove orphans by itself",
+			command: "down",
+			args:    []string{"--include", "web"},
+			want:    []string{"down"},
+		},
+		{
+			name:    "up removes orphans",
+			command: "up",
+			args:    []string{"--include", "web", "-d"},
+			want:    []string{"up", "-d", "--remove-orphans"},
+		},
+		{
+			name:    "up --remove-orphans is not repeated",
+			command: "up",
+			args:    []string{"--remove-orphans", "--include", "web"},
+			want:    []string{"up", "--remove-orphans"},
+		},
+		{
+			name:    "up --remove-orphans=false is respected",
+			command: "up",
+			args:    []string{"--include", "web", "--remove-orphans=false"},
+			want:    []string{"up", "--remove-orphans=false"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs(tt.command, tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+			if want := []string{"web"}; !reflect.DeepEqual(cmdArgs.IncludeServices, want) {
+				t.Errorf("include = %q, want %q", cmdArgs.IncludeServices, want)
+			}
+
+			want := append(append([]string(nil), prefix...), tt.want...)
+			if got := filteredComposeArgs(project, "/tmp/quay.yml", tt.command, cmdArgs); !reflect.DeepEqual(got, want) {
+				t.Errorf("args = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSplitImageRegistry(t *testing.T) {
+	const digest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+	tests := []struct {
+		image         string
+		wantRegistry  string
+		wantRemainder string
+	}{
+		{image: "nginx", wantRemainder: "nginx"},
+		{image: "postgres:16", wantRemainder: "postgres:16"},
+		{image: "library/nginx", wantRemainder: "library/nginx"},
+		{image: "acme/api:2", wantRemainder: "acme/api:2"},
+		{image: "nginx@" + digest, wantRemainder: "nginx@" + digest},
+		{image: "acme/api:2@" + digest, wantRemainder: "acme/api:2@" + digest},
+		{image: "localhost/api", wantRegistry: "localhost", wantRemainder: "api"},
+		{image: "localhost:5000/api", wantRegistry: "localhost:5000", wantRemainder: "api"},
+		{image: "registry.example.com:5000/team/api:2", wantRegistry: "registry.example.com:5000", wantRemainder: "team/api:2"},
+		{image: "ghcr.io/acme/api@" + digest, wantRegistry: "ghcr.io", wantRemainder: "acme/api@" + digest},
+		{image: "docker.io/library/nginx:1", wantRegistry: "docker.io", wantRemainder: "library/nginx:1"},
+		{image: "docker.io/nginx", wantRegistry: "docker.io", wantRemainder: "nginx"},
+		{image: "index.docker.io/acme/api", wantRegistry: "index.docker.io", wantRemainder: "acme/api"},
+		{image: "ghcr.io/ghcr.io/api", wantRegistry: "ghcr.io", wantRemainder: "ghcr.io/api"},
+		// Repository paths are lowercase, so Docker takes an uppercase component for a registry
+		{image: "Acme/api", wantRegistry: "Acme", wantRemainder: "api"},
+		{image: "acme/API", wantRemainder: "acme/API"},
+	}
+
+	for _, tt := range tests {
+		registry, remainder := splitImageRegistry(tt.image)
+		if registry != tt.wantRegistry || remainder != tt.wantRemainder {
+			t.Errorf("splitImageRegistry(%q) = %q, %q, want %q, %q", tt.image, registry, remainder, tt.wantRegistry, tt.wantRemainder)
+		}
+	}
+}
+
+func TestCommandOverrides(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		want       string
+		wantAbsent string
+	}{
+		{
+			name:       "unset keeps the compose file",
+			want:       "entrypoint:\n            - /docker-entrypoint.sh\n",
+			wantAbsent: "command",
+		},
+		{
+			name: "empty entrypoint",
+			args: []string{"--entrypoint", "web="},
+			want: "entrypoint: []\n",
+		},
+		{
+			name: "empty command",
+			args: []string{"--command", "web="},
+			want: "command: []\n",
+		},
+		{
+			name: "blank command",
+			args: []string{"--command", "web=  "},
+			want: "command: []\n",
+		},
+		{
+			name: "command with quoted arguments",
+			args: []string{"--command", `web=sh -c "sleep 1 && echo done"`},
+			want: "command:\n            - sh\n            - -c\n            - sleep 1 && echo done\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs("up", tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+
+			project := &types.Project{
+				Name: "shop",
+				Services: types.Services{
+					"web": {Name: "web", Image: "nginx", Entrypoint: types.ShellCommand{"/docker-entrypoint.sh"}},
+				},
+			}
+			if missing := applyCommandOverrides(project, cmdArgs.commands, cmdArgs.entrypoints); len(missing) > 0 {
+				t.Fatalf("missing services: %q", missing)
+			}
+
+			yamlData, err := marshalProject(project)
+			if err != nil {
+				t.Fatalf("marshalProject: %v", err)
+			}
+			if !strings.Contains(string(yamlData), tt.want) {
+				t.Errorf("rendered project does not contain %q:\n%s", tt.want, yamlData)
+			}
+			if tt.wantAbsent != "" && strings.Contains(string(yamlData), tt.wantAbsent) {
+				t.Errorf("rendered project contains %q:\n%s", tt.wantAbsent, yamlData)
+			}
+		})
+	}
+}
+
+func TestParsePortOffset(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "1000", want: 1000},
+		{value: "-1000", want: -1000},
+		{value: "+5", want: 5},
+		{value: "0", want: 0},
+		{value: "65534", want: 65534},
+		{value: "-65534", want: -65534},
+		{value: "65535", wantErr: true},
+		{value: "-65535", wantErr: true},
+		{value: "99999999999999999999", wantErr: true},
+		{value: "1.5", wantErr: true},
+		{value: "ten", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		cmdArgs, err := parseRemainingArgs("up", []string{"--port-offset", tt.value})
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid port offset '"+tt.value+"'") {
+				t.Errorf("--port-offset %q: error = %v, want an invalid port offset error", tt.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("--port-offset %q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if cmdArgs.portOffset != tt.want {
+			t.Errorf("--port-offset %q = %d, want %d", tt.value, cmdArgs.portOffset, tt.want)
+		}
+	}
+}
+
+func TestApplyPortOffset(t *testing.T) {
+	tests := []struct {
+		published string
+		offset    int
+		want      string
+		wantErr   bool
+	}{
+		{published: "8080", offset: 1000, want: "9080"},
+		{published: "8080", offset: -8079, want: "1"},
+		{published: "8080", offset: -8080, wantErr: true},
+		{published: "65000", offset: 535, want: "65535"},
+		{published: "65000", offset: 536, wantErr: true},
+		{published: "8000-8010", offset: 100, want: "8100-8110"},
+		{published: "65530-65535", offset: 1, wantErr: true},
+		{published: "0", offset: 1000, want: "0"},
+		{published: "", offset: 1000, want: ""},
+	}
+
+	for _, tt := range tests {
+		project := &types.Project{Services: types.Services{
+			"web": {Name: "web", Ports: []types.ServicePortConfig{{Published: tt.published, Target: 80}}},
+		}}
+		err := applyPortOffset(project, tt.offset)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("offset %d of %q: want an error", tt.offset, tt.published)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("offset %d of %q: unexpected error: %v", tt.offset, tt.published, err)
+			continue
+		}
+		if got := project.Services["web"].Ports[0].Published; got != tt.want {
+			t.Errorf("offset %d of %q = %q, want %q", tt.offset, tt.published, got, tt.want)
+		}
+	}
+}
+
+func TestParseLabelSelectors(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantInclude []string
+		wantExclude []string
+		wantErr     string
+	}{
+		{args: []string{"--include-label", "tier=web"}, wantInclude: []string{"tier=web"}},
+		{args: []string{"--include-label", "tier="}, wantInclude: []string{"tier="}},
+		{args: []string{"--exclude-label", "com.example/role=db=primary"}, wantExclude: []string{"com.example/role=db=primary"}},
+		{args: []string{"--include-label", "tier"}, wantErr: "invalid label selector 'tier': expected KEY=VALUE"},
+		{args: []string{"--exclude-label", "tier"}, wantErr: "invalid label selector 'tier': expected KEY=VALUE"},
+		{args: []string{"--include-label", "=web"}, wantErr: "invalid label selector '=web': expected KEY=VALUE"},
+		{args: []string{"--exclude-label", ""}, wantErr: "invalid label selector '': expected KEY=VALUE"},
+	}
+
+	for _, tt := range tests {
+		cmdArgs, err := parseRemainingArgs("up", tt.args)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%q: error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.args, err)
+			continue
+		}
+		if !equalStrings(cmdArgs.IncludeLabels, tt.wantInclude) || !equalStrings(cmdArgs.ExcludeLabels, tt.wantExclude) {
+			t.Errorf("%q: labels = %q, %q, want %q, %q", tt.args, cmdArgs.IncludeLabels, cmdArgs.ExcludeLabels, tt.wantInclude, tt.wantExclude)
+		}
+	}
+}
+
+func TestPortConflicts(t *testing.T) {
+	ports := func(specs ...types.ServicePortConfig) []types.ServicePortConfig { return specs }
+	tests := []struct {
+		name     string
+		services types.Services
+		want     []string
+	}{
+		{
+			name: "distinct ports",
+			services: types.Services{
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 80})},
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{Published: "8081", Target: 80})},
+			},
+		},
+		{
+			name: "same port",
+			services: types.Services{
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 80})},
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 3000})},
+			},
+			want: []string{"api and web both publish 8080/tcp"},
+		},
+		{
+			name: "same port, different protocols",
+			services: types.Services{
+				"dns": {Name: "dns", Ports: ports(types.ServicePortConfig{Published: "53", Target: 53, Protocol: "udp"})},
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "53", Target: 53})},
+			},
+		},
+		{
+			name: "same port on different addresses",
+			services: types.Services{
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{HostIP: "127.0.0.1", Published: "8080", Target: 80})},
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{HostIP: "::1", Published: "8080", Target: 80})},
+			},
+		},
+		{
+			name: "all interfaces after a specific address",
+			services: types.Services{
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{HostIP: "127.0.0.1", Published: "8080", Target: 80})},
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 80})},
+			},
+			want: []string{"api and web both publish 8080/tcp"},
+		},
+		{
+			name: "specific address after all interfaces",
+			services: types.Services{
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 80})},
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{HostIP: "127.0.0.1", Published: "8080", Target: 80})},
+			},
+			want: []string{"api and web both publish 127.0.0.1:8080/tcp"},
+		},
+		{
+			name: "overlapping ranges",
+			services: types.Services{
+				"media": {Name: "media", Ports: ports(types.ServicePortConfig{Published: "8000-8002", Target: 9000})},
+				"web":   {Name: "web", Ports: ports(types.ServicePortConfig{Published: "8002-8003", Target: 80})},
+			},
+			want: []string{"media and web both publish 8002/tcp"},
+		},
+		{
+			name: "ports left to Docker never conflict",
+			services: types.Services{
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "0", Target: 80}, types.ServicePortConfig{Target: 443})},
+				"api": {Name: "api", Ports: ports(types.ServicePortConfig{Published: "0", Target: 80}, types.ServicePortConfig{Target: 443})},
+			},
+		},
+		{
+			name: "a service publishing a port twice",
+			services: types.Services{
+				"web": {Name: "web", Ports: ports(types.ServicePortConfig{Published: "8080", Target: 80}, types.ServicePortConfig{Published: "8080", Target: 8080})},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := portConflicts(&types.Project{Services: tt.services})
+			if !equalStrings(got, tt.want) {
+				t.Errorf("conflicts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeHostPorts(t *testing.T) {
+	project := &types.Project{Services: types.Services{
+		"web": {Name: "web", Ports: []types.ServicePortConfig{
+			{Published: "8080", Target: 80},
+			{HostIP: "::1", Published: "8443", Target: 443},
+			{Published: "0", Target: 9000},
+		}},
+		"dns": {Name: "dns", Ports: []types.ServicePortConfig{{Published: "5353-5354", Target: 53, Protocol: "udp"}}},
+	}}
+
+	busy := map[string]bool{"[::1]:8443/tcp": true, "5354/udp": true}
+	var probed []string
+	warnings := probeHostPorts(project, func(port hostPort) error {
+		probed = append(probed, port.String())
+		if busy[port.String()] {
+			return errors.New("address already in use")
+		}
+		return nil
+	})
+
+	if want := []string{"5353/udp", "5354/udp", "8080/tcp", "[::1]:8443/tcp"}; !reflect.DeepEqual(probed, want) {
+		t.Errorf("probed %q, want %q", probed, want)
+	}
+	want := []string{
+		"host port 5354/udp of service dns is not available: address already in use",
+		"host port [::1]:8443/tcp of service web is not available: address already in use",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q, want %q", warnings, want)
+	}
+}
+
+func TestListenOnHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on the loopback interface: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	if err := listenOnHost(hostPort{ip: "127.0.0.1", port: port, protocol: "tcp"}); err == nil {
+		t.Errorf("port %d is in use, but listenOnHost reported it free", port)
+	}
+	listener.Close()
+	if err := listenOnHost(hostPort{ip: "127.0.0.1", port: port, protocol: "tcp"}); err != nil {
+		t.Errorf("port %d is free, but listenOnHost failed: %v", port, err)
+	}
+}
+
+// useDockerComposePlugin makes dry runs print the docker compose plugin command, whatever is
+// installed on the machine running the tests
+func useDockerComposePlugin() {
+	composeCommand.once.Do(func() {})
+	composeCommand.bin, composeCommand.prefix, composeCommand.err = "docker", []string{"compose"}, nil
+}
+
+func TestDryRunGolden(t *testing.T) {
+	useDockerComposePlugin()
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		golden  string
+	}{
+		{
+			name:    "filtered up",
+			command: "up",
+			args:    []string{"-d", "--include", "web", "--port", "web:8081:80", "--env", "web:LOG_LEVEL=debug"},
+			golden:  "dry-run-up",
+		},
+		{
+			name:    "filtered run with quoting",
+			command: "run",
+			args:    []string{"--exclude", "web", "--rm", "api", "sh", "-c", "echo 'ready' && env"},
+			golden:  "dry-run-run",
+		},
+		{
+			name:    "passthrough",
+			command: "logs",
+			args:    []string{"-f", "--tail", "10"},
+			golden:  "dry-run-logs",
+		},
+		{
+			name:    "passthrough with quoting",
+			command: "exec",
+			args:    []string{"api", "sh", "-c", "echo $HOME"},
+			golden:  "dry-run-exec",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs(tt.command, tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+			cmdArgs.dryRun = true
+			composePaths := goldenComposePaths(t)
+
+			stdout, stderr, err := captureOutput(t, func() error {
+				if cmdArgs.needsProjectRewrite() {
+					return executeFilteredCommand(composePaths, tt.command, cmdArgs)
+				}
+				projectName, err := resolveProjectName(composePaths, cmdArgs)
+				if err != nil {
+					return err
+				}
+				return executePassthroughCommand(composePaths, passthroughComposeArgs(projectName, tt.command, cmdArgs), true, false)
+			})
+			if err != nil {
+				t.Fatalf("dry run: %v", err)
+			}
+			checkGolden(t, tt.golden+".stdout.golden", stdout)
+			checkGolden(t, tt.golden+".stderr.golden", stderr)
+		})
+	}
+}
+
+func TestParseEntrypointOverride(t *testing.T) {
+	tests := []struct {
+		name            string
+		command         string
+		args            []string
+		wantEntrypoints []CommandOverride
+		wantOptions     []string
+		wantRewrite     bool
+	}{
+		{
+			name:            "quay override for up",
+			command:         "up",
+			args:            []string{"--entrypoint", "web=/bin/sh -c"},
+			wantEntrypoints: []CommandOverride{{ServiceName: "web", Args: []string{"/bin/sh", "-c"}}},
+			wantRewrite:     true,
+		},
+		{
+			name:        "run keeps its own option",
+			command:     "run",
+			args:        []string{"--entrypoint", "/bin/sh", "api"},
+			wantOptions: []string{"--entrypoint", "/bin/sh", "api"},
+		},
+		{
+			name:        "run passes SERVICE=COMMAND values on as well",
+			command:     "run",
+			args:        []string{"--entrypoint", "api=x", "api"},
+			wantOptions: []string{"--entrypoint", "api=x", "api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmdArgs, err := parseRemainingArgs(tt.command, tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+			if !reflect.DeepEqual(cmdArgs.entrypoints, tt.wantEntrypoints) {
+				t.Errorf("entrypoints = %+v, want %+v", cmdArgs.entrypoints, tt.wantEntrypoints)
+			}
+			if !equalStrings(cmdArgs.cmdOptions, tt.wantOptions) {
+				t.Errorf("options = %q, want %q", cmdArgs.cmdOptions, tt.wantOptions)
+			}
+			if cmdArgs.needsProjectRewrite() != tt.wantRewrite {
+				t.Errorf("needsProjectRewrite = %v, want %v", cmdArgs.needsProjectRewrite(), tt.wantRewrite)
+			}
+		})
+	}
+}
+
+func TestParseVolumeMount(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    VolumeMount
+		wantErr string
+	}{
+		{value: "web:./html:/usr/share/nginx/html", want: VolumeMount{ServiceName: "web", HostPath: "./html", ContainerPath: "/usr/share/nginx/html"}},
+		{value: "web:/etc/app.conf:/etc/app.conf:ro", want: VolumeMount{ServiceName: "web", HostPath: "/etc/app.conf", ContainerPath: "/etc/app.conf", ReadOnly: true}},
+		{value: "web:/data:/data:rw", want: VolumeMount{ServiceName: "web", HostPath: "/data", ContainerPath: "/data"}},
+		{value: `web:C:\data:/data`, want: VolumeMount{ServiceName: "web", HostPath: `C:\data`, ContainerPath: "/data"}},
+		{value: "web:/data", wantErr: "invalid format"},
+		{value: "web:/data:ro", wantErr: "invalid format"},
+		{value: ":/data:/data", wantErr: "invalid format"},
+		{value: "web::/data", wantErr: "invalid format"},
+		{value: "web", wantErr: "invalid format"},
+		{value: "web:/data:data", wantErr: "container path data is not absolute"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseVolumeMount(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseVolumeMount(%q): error = %v, want it to contain %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseVolumeMount(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVolumeMount(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseVolumeOption(t *testing.T) {
+	cmdArgs, err := parseRemainingArgs("up", []string{"--volume", "web:./html:/html"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	if want := []VolumeMount{{ServiceName: "web", HostPath: "./html", ContainerPath: "/html"}}; !reflect.DeepEqual(cmdArgs.volumes, want) {
+		t.Errorf("volumes = %+v, want %+v", cmdArgs.volumes, want)
+	}
+
+	// run has its own --volume option
+	cmdArgs, err = parseRemainingArgs("run", []string{"--volume", "/tmp:/data", "api", "ls"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	if want := []string{"--volume", "/tmp:/data", "api", "ls"}; !reflect.DeepEqual(cmdArgs.cmdOptions, want) {
+		t.Errorf("options = %q, want %q", cmdArgs.cmdOptions, want)
+	}
+	if cmdArgs.needsProjectRewrite() {
+		t.Errorf("run --volume forces the filtered path")
+	}
+}
+
+func TestApplyVolumeMounts(t *testing.T) {
+	original := []types.ServiceVolumeConfig{
+		{Type: types.VolumeTypeVolume, Source: "data", Target: "/data"},
+		{Type: types.VolumeTypeBind, Source: "/srv/shop/conf", Target: "/etc/app"},
+	}
+	project := &types.Project{
+		WorkingDir: "/srv/shop",
+		Services:   types.Services{"web": {Name: "web", Volumes: original}},
+	}
+
+	missing := applyVolumeMounts(project, []VolumeMount{
+		{ServiceName: "web", HostPath: "./local", ContainerPath: "/etc/app", ReadOnly: true},
+		{ServiceName: "web", HostPath: "/var/log/shop", ContainerPath: "/logs"},
+		{ServiceName: "proxy", HostPath: "/tmp", ContainerPath: "/tmp"},
+	})
+
+	if want := []string{"proxy"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing = %q, want %q", missing, want)
+	}
+	want := []types.ServiceVolumeConfig{
+		{Type: types.VolumeTypeVolume, Source: "data", Target: "/data"},
+		{Type: types.VolumeTypeBind, Source: filepath.Join("/srv/shop", "local"), Target: "/etc/app", ReadOnly: true},
+		{Type: types.VolumeTypeBind, Source: "/var/log/shop", Target: "/logs"},
+	}
+	if got := project.Services["web"].Volumes; !reflect.DeepEqual(got, want) {
+		t.Errorf("volumes = %+v, want %+v", got, want)
+	}
+	if original[1].Source != "/srv/shop/conf" {
+		t.Errorf("the volumes of the original service were modified")
+	}
+}
+
+func TestParseEnvOption(t *testing.T) {
+	tests := []struct {
+		command       string
+		args          []string
+		wantOverrides []EnvOverride
+		wantOptions   []string
+	}{
+		{
+			command:       "up",
+			args:          []string{"--env", "web:LOG_LEVEL=debug", "-e", "FOO=bar"},
+			wantOverrides: []EnvOverride{{ServiceName: "web", Key: "LOG_LEVEL", Value: "debug"}, {Key: "FOO", Value: "bar"}},
+		},
+		{
+			command:     "run",
+			args:        []string{"--env", "FOO=bar", "api", "env"},
+			wantOptions: []string{"--env", "FOO=bar", "api", "env"},
+		},
+		{
+			command:     "run",
+			args:        []string{"-e", "FOO=bar", "api", "env"},
+			wantOptions: []string{"-e", "FOO=bar", "api", "env"},
+		},
+		{
+			command:     "exec",
+			args:        []string{"--env", "FOO=bar", "api", "env"},
+			wantOptions: []string{"--env", "FOO=bar", "api", "env"},
+		},
+	}
+
+	for _, tt := range tests {
+		cmdArgs, err := parseRemainingArgs(tt.command, tt.args)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.command, tt.args, err)
+		}
+		if !reflect.DeepEqual(cmdArgs.envOverrides, tt.wantOverrides) {
+			t.Errorf("%s %q: overrides = %+v, want %+v", tt.command, tt.args, cmdArgs.envOverrides, tt.wantOverrides)
+		}
+		if !equalStrings(cmdArgs.cmdOptions, tt.wantOptions) {
+			t.Errorf("%s %q: options = %q, want %q", tt.command, tt.args, cmdArgs.cmdOptions, tt.wantOptions)
+		}
+		if cmdArgs.needsProjectRewrite() != (len(tt.wantOverrides) > 0) {
+			t.Errorf("%s %q: needsProjectRewrite = %v", tt.command, tt.args, cmdArgs.needsProjectRewrite())
+		}
+	}
+}
+
+func TestParseEnvOverride(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    EnvOverride
+		wantErr bool
+	}{
+		{value: "web:LOG_LEVEL=debug", want: EnvOverride{ServiceName: "web", Key: "LOG_LEVEL", Value: "debug"}},
+		{value: "LOG_LEVEL=debug", want: EnvOverride{Key: "LOG_LEVEL", Value: "debug"}},
+		{value: "web:EMPTY=", want: EnvOverride{ServiceName: "web", Key: "EMPTY"}},
+		{value: "web:URL=postgres://db:5432/app?a=b,c", want: EnvOverride{ServiceName: "web", Key: "URL", Value: "postgres://db:5432/app?a=b,c"}},
+		{value: "LOG_LEVEL", wantErr: true},
+		{value: "=debug", wantErr: true},
+		{value: "web:=debug", wantErr: true},
+		{value: ":LOG_LEVEL=debug", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseEnvOverride(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseEnvOverride(%q) = %+v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEnvOverride(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseEnvOverride(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExcludedMiddleServiceRendersValidProject(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	composePath := writeComposeFile(t, t.TempDir(), `name: chain
+services:
+  web:
+    image: nginx
+    depends_on:
+      api:
+        condition: service_started
+  api:
+    image: api
+    depends_on:
+      - db
+  db:
+    image: postgres
+`)
+
+	cmdArgs, err := parseRemainingArgs("up", []string{"--exclude", "api"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	project, err := loadFilteredProject([]string{composePath}, cmdArgs)
+	if err != nil {
+		t.Fatalf("loadFilteredProject: %v", err)
+	}
+	yamlData, err := marshalProject(project)
+	if err != nil {
+		t.Fatalf("marshalProject: %v", err)
+	}
+
+	// Compose rejects depends_on entries naming services that don't exist
+	reloaded, err := loader.LoadWithContext(context.Background(), types.ConfigDetails{
+		WorkingDir:  project.WorkingDir,
+		ConfigFiles: []types.ConfigFile{{Filename: "filtered.yml", Content: yamlData}},
+		Environment: types.Mapping{},
+	})
+	if err != nil {
+		t.Fatalf("compose rejects the rendered project: %v\n%s", err, yamlData)
+	}
+	if want := []string{"db", "web"}; !reflect.DeepEqual(reloaded.ServiceNames(), want) {
+		t.Errorf("services = %q, want %q", reloaded.ServiceNames(), want)
+	}
+	if dependsOn := reloaded.Services["web"].DependsOn; len(dependsOn) != 0 {
+		t.Errorf("web still depends on %v", dependsOn)
+	}
+}
+
+func TestSuggestService(t *testing.T) {
+	project := &types.Project{Services: types.Services{
+		"postgres": {Name: "postgres"},
+		"redis":    {Name: "redis"},
+		"web":      {Name: "web"},
+		"worker":   {Name: "worker"},
+		"api":      {Name: "api"},
+	}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "postgress", want: "postgres"},
+		{name: "postgrs", want: "postgres"},
+		{name: "postgers", want: "postgres"},
+		{name: "postgresql", want: "postgres"},
+		{name: "postgreSQL1", want: ""},
+		{name: "rdis", want: "redis"},
+		{name: "wbe", want: "web"},
+		{name: "workr", want: "worker"},
+		{name: "ab", want: ""},
+		{name: "xyz", want: ""},
+		{name: "w*", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := suggestService(tt.name, project); got != tt.want {
+			t.Errorf("suggestService(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"web", "web", 0},
+		{"web", "wbe", 1},
+		{"web", "webs", 1},
+		{"web", "we", 1},
+		{"redis", "rdeis", 1},
+		{"postgres", "postgresql", 2},
+		{"api", "xyz", 3},
+		{"", "web", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMissingServiceSuggestions(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	composePath := writeComposeFile(t, t.TempDir(), `name: similar
+services:
+  postgres:
+    image: postgres
+  postgres-replica:
+    image: postgres
+  web:
+    image: nginx
+`)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--include", "postgress"}, want: "postgress (did you mean 'postgres'?)"},
+		{args: []string{"--exclude", "wbe"}, want: "wbe (did you mean 'web'?)"},
+		{args: []string{"--port", "postgres-replca:5433:5432"}, want: "postgres-replca (did you mean 'postgres-replica'?)"},
+		{args: []string{"--include", "cache"}, want: "services not found in the docker-compose file: cache (available services"},
+	}
+
+	for _, tt := range tests {
+		cmdArgs, err := parseRemainingArgs("up", append(tt.args, "--strict"))
+		if err != nil {
+			t.Fatalf("parseRemainingArgs: %v", err)
+		}
+		_, _, err = loadProjects([]string{composePath}, cmdArgs)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error = %v, want it to contain %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestProfiledServices(t *testing.T) {
+	composePath := filepath.Join("testdata", "profiles", "compose.yaml")
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     string
+		want    []string
+		notices string
+	}{
+		{name: "no profile", want: []string{"db", "web"}},
+		{name: "profile flag", args: []string{"--profile", "debug"}, want: []string{"db", "mailhog", "pgadmin", "web"}},
+		{name: "repeated profile flag", args: []string{"--profile", "mail", "--profile", "metrics"}, want: []string{"db", "grafana", "mailhog", "web"}},
+		{name: "COMPOSE_PROFILES", env: "metrics", want: []string{"db", "grafana", "web"}},
+		{name: "COMPOSE_PROFILES list", env: "debug,metrics", want: []string{"db", "grafana", "mailhog", "pgadmin", "web"}},
+		{
+			name:    "included service enables its profile",
+			args:    []string{"--include", "pgadmin"},
+			want:    []string{"pgadmin"},
+			notices: "Enabling profile 'debug' for service pgadmin\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COMPOSE_PROJECT_NAME", "")
+			t.Setenv("COMPOSE_PROFILES", tt.env)
+
+			var notices strings.Builder
+			defer func(writer io.Writer) { diagnostics = writer }(diagnostics)
+			diagnostics = &notices
+
+			cmdArgs, err := parseRemainingArgs("up", tt.args)
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+			_, filteredProject, err := loadProjects([]string{composePath}, cmdArgs)
+			if err != nil {
+				t.Fatalf("loadProjects: %v", err)
+			}
+			if got := filteredProject.ServiceNames(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("services = %q, want %q", got, tt.want)
+			}
+			if notices.String() != tt.notices {
+				t.Errorf("notices = %q, want %q", notices.String(), tt.notices)
+			}
+		})
+	}
+}
+
+func TestProfileHints(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	t.Setenv("COMPOSE_PROFILES", "")
+
+	cmdArgs, err := parseRemainingArgs("up", nil)
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	project, _, err := loadProjects([]string{filepath.Join("testdata", "profiles", "compose.yaml")}, cmdArgs)
+	if err != nil {
+		t.Fatalf("loadProjects: %v", err)
+	}
+
+	got := profileHints(project, []string{"web", "mailhog", "cache", "grafana"})
+	want := []string{
+		"service mailhog is only enabled with profile debug or mail (use --profile debug)",
+		"service grafana is only enabled with profile metrics (use --profile metrics)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("profileHints = %q, want %q", got, want)
+	}
+}
+
+func TestRequiredReferenceFixtures(t *testing.T) {
+	defer func(writer io.Writer) { diagnostics = writer }(diagnostics)
+	diagnostics = io.Discard
+
+	for _, kind := range []string{"network_mode", "ipc", "pid", "volumes_from", "links"} {
+		t.Run(kind, func(t *testing.T) {
+			t.Setenv("COMPOSE_PROJECT_NAME", "")
+			composePaths := []string{filepath.Join("testdata", "required", kind+".yaml")}
+
+			for _, args := range [][]string{{"--include", "app"}, {"--exclude", "shared"}} {
+				cmdArgs, err := parseRemainingArgs("up", args)
+				if err != nil {
+					t.Fatalf("parseRemainingArgs: %v", err)
+				}
+				project, err := loadFilteredProject(composePaths, cmdArgs)
+				if err != nil {
+					t.Fatalf("%q: loadFilteredProject: %v", args, err)
+				}
+				if want := []string{"app", "shared"}; !reflect.DeepEqual(project.ServiceNames(), want) {
+					t.Errorf("%q: services = %q, want %q", args, project.ServiceNames(), want)
+				}
+			}
+
+			cmdArgs, err := parseRemainingArgs("up", []string{"--exclude", "shared", "--no-keep-required"})
+			if err != nil {
+				t.Fatalf("parseRemainingArgs: %v", err)
+			}
+			_, err = loadFilteredProject(composePaths, cmdArgs)
+			if want := "app -> shared (" + kind + ")"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("error = %v, want it to contain %q", err, want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvUnsets(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "worker.env"), []byte("SENTRY_DSN=https://sentry.example.com/1\nQUEUE=jobs\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	composePath := writeComposeFile(t, dir, `name: unset
+services:
+  worker:
+    image: worker
+    env_file: worker.env
+    environment:
+      LOG_LEVEL: debug
+      QUEUE: priority
+  web:
+    image: nginx
+    environment:
+      SENTRY_DSN: https://sentry.example.com/2
+      LOG_LEVEL: info
+`)
+
+	project, err := loadFilteredProject([]string{composePath}, commandArgs{})
+	if err != nil {
+		t.Fatalf("loadFilteredProject: %v", err)
+	}
+	original := project.Services["worker"].Environment
+
+	missingServices, absentVariables := applyEnvUnsets(project, []EnvUnset{
+		{ServiceName: "worker", Key: "SENTRY_DSN"},
+		{ServiceName: "worker", Key: "QUEUE"},
+		{ServiceName: "worker", Key: "MISSING"},
+		{ServiceName: "web", Key: "SENTRY_DSN"},
+		{ServiceName: "cache", Key: "SENTRY_DSN"},
+	})
+	if want := []string{"cache"}; !reflect.DeepEqual(missingServices, want) {
+		t.Errorf("missing services = %q, want %q", missingServices, want)
+	}
+	if want := []EnvUnset{{ServiceName: "worker", Key: "MISSING"}}; !reflect.DeepEqual(absentVariables, want) {
+		t.Errorf("absent variables = %v, want %v", absentVariables, want)
+	}
+
+	// Variables of services with env_file are nulled so the env file can't set them again
+	worker := project.Services["worker"].Environment
+	for _, key := range []string{"SENTRY_DSN", "QUEUE"} {
+		if value, set := worker[key]; !set || value != nil {
+			t.Errorf("worker %s = %v, want it set to null", key, value)
+		}
+	}
+	if value := worker["LOG_LEVEL"]; value == nil || *value != "debug" {
+		t.Errorf("worker LOG_LEVEL = %v, want debug", value)
+	}
+	if value := original["SENTRY_DSN"]; value == nil {
+		t.Error("unsetting changed the environment of the unfiltered project")
+	}
+
+	if _, set := project.Services["web"].Environment["SENTRY_DSN"]; set {
+		t.Error("web SENTRY_DSN is still set")
+	}
+
+	yamlData, err := marshalProject(project)
+	if err != nil {
+		t.Fatalf("marshalProject: %v", err)
+	}
+	if !strings.Contains(string(yamlData), "SENTRY_DSN: null") || !strings.Contains(string(yamlData), "worker.env") {
+		t.Errorf("rendered project doesn't null SENTRY_DSN over the env file:\n%s", yamlData)
+	}
+}
+
+func TestDefaultComposeFileIn(t *testing.T) {
+	defer func(writer io.Writer) { diagnostics = writer }(diagnostics)
+
+	tests := []struct {
+		files  []string
+		want   string
+		notice string
+	}{
+		{files: nil, want: ""},
+		{files: []string{"docker-compose.yaml"}, want: "docker-compose.yaml"},
+		{files: []string{"docker-compose.yml", "docker-compose.yaml"}, want: "docker-compose.yml", notice: "Found multiple compose files (docker-compose.yml, docker-compose.yaml)"},
+		{files: []string{"compose.yml", "docker-compose.yml"}, want: "compose.yml", notice: "Found multiple compose files (compose.yml, docker-compose.yml)"},
+		{files: []string{"compose.yaml", "compose.yml", "docker-compose.yml"}, want: "compose.yaml", notice: "Found multiple compose files (compose.yaml, compose.yml, docker-compose.yml)"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, filename := range tt.files {
+			if err := os.WriteFile(filepath.Join(dir, filename), []byte("services: {}\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var notices strings.Builder
+		diagnostics = &notices
+		got, found := defaultComposeFileIn(dir)
+
+		want := ""
+		if tt.want != "" {
+			want = filepath.Join(dir, tt.want)
+		}
+		if got != want || found != (tt.want != "") {
+			t.Errorf("%q: defaultComposeFileIn = %q, %v, want %q", tt.files, got, found, want)
+		}
+		if !strings.Contains(notices.String(), tt.notice) || (tt.notice == "") != (notices.Len() == 0) {
+			t.Errorf("%q: notice = %q, want %q", tt.files, notices.String(), tt.notice)
+		}
+	}
+}
+
+func TestFindComposeFileSearchesParents(t *testing.T) {
+	t.Setenv("COMPOSE_FILE", "")
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	composePath := writeComposeFile(t, root, "services: {}\n")
+	nested := filepath.Join(root, "app", "src")
+	repo := filepath.Join(root, "repo")
+	for _, dir := range []string{nested, filepath.Join(repo, ".git"), filepath.Join(repo, "cmd", "tool")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Chdir(nested)
+	got, err := findComposeFile(nil, true)
+	if err != nil {
+		t.Fatalf("findComposeFile: %v", err)
+	}
+	if want := []string{composePath}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findComposeFile = %q, want %q", got, want)
+	}
+
+	if _, err := findComposeFile(nil, false); err == nil {
+		t.Error("findComposeFile without searching parents found a compose file")
+	}
+
+	// The search ends at the root of a git checkout, so the compose file above it is ignored
+	t.Chdir(filepath.Join(repo, "cmd", "tool"))
+	if got, err := findComposeFile(nil, true); err == nil {
+		t.Errorf("findComposeFile crossed the .git boundary and found %q", got)
+	}
+
+	composePath = writeComposeFile(t, repo, "services: {}\n")
+	got, err = findComposeFile(nil, true)
+	if err != nil {
+		t.Fatalf("findComposeFile: %v", err)
+	}
+	if want := []string{composePath}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findComposeFile = %q, want %q", got, want)
+	}
+}
+
+func TestSplitComposeFileList(t *testing.T) {
+	tests := []struct {
+		value     string
+		separator string
+		want      []string
+	}{
+		{value: "", want: nil},
+		{value: "compose.yaml", want: []string{"compose.yaml"}},
+		{value: "compose.yaml" + string(os.PathListSeparator) + "compose.dev.yaml", want: []string{"compose.yaml", "compose.dev.yaml"}},
+		{value: `C:\app\compose.yaml;C:\app\compose.dev.yaml`, separator: ";", want: []string{`C:\app\compose.yaml`, `C:\app\compose.dev.yaml`}},
+		{value: "compose.yaml;;compose.dev.yaml;", separator: ";", want: []string{"compose.yaml", "compose.dev.yaml"}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("COMPOSE_PATH_SEPARATOR", tt.separator)
+		if got := splitComposeFileList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitComposeFileList(%q) with separator %q = %q, want %q", tt.value, tt.separator, got, tt.want)
+		}
+	}
+}
+
+func TestFindComposeFileFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	base := writeComposeFile(t, dir, "services: {}\n")
+	override := filepath.Join(dir, "compose.dev.yaml")
+	if err := os.WriteFile(override, []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("COMPOSE_PATH_SEPARATOR", ";")
+	t.Setenv("COMPOSE_FILE", base+";"+override)
+	got, err := findComposeFile(nil, true)
+	if err != nil {
+		t.Fatalf("findComposeFile: %v", err)
+	}
+	if want := []string{base, override}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findComposeFile = %q, want %q", got, want)
+	}
+
+	t.Setenv("COMPOSE_FILE", base+";"+filepath.Join(dir, "missing.yaml"))
+	if _, err := findComposeFile(nil, true); err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("error = %v, want it to name missing.yaml", err)
+	}
+}
+
+func TestDetectComposeCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		outputs    map[string]string
+		wantBin    string
+		wantPrefix []string
+		wantErr    string
+		wantCalls  []string
+	}{
+		{
+			name:       "plugin",
+			outputs:    map[string]string{"docker compose version": "Docker Compose version v2.29.1"},
+			wantBin:    "docker",
+			wantPrefix: []string{"compose"},
+			wantCalls:  []string{"docker compose version"},
+		},
+		{
+			name:      "standalone binary",
+			outputs:   map[string]string{"docker-compose version": "docker-compose version 1.29.2"},
+			wantBin:   "docker-compose",
+			wantCalls: []string{"docker compose version", "docker-compose version"},
+		},
+		{
+			name: "plugin preferred over the binary",
+			outputs: map[string]string{
+				"docker compose version": "Docker Compose version v2.29.1",
+				"docker-compose version": "docker-compose version 1.29.2",
+			},
+			wantBin:    "docker",
+			wantPrefix: []string{"compose"},
+			wantCalls:  []string{"docker compose version"},
+		},
+		{
+			name:      "neither",
+			wantErr:   "docker compose not found",
+			wantCalls: []string{"docker compose version", "docker-compose version"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			bin, prefix, err := detectComposeCommand("", fakeRunner(tt.outputs, &calls))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if bin != tt.wantBin || !reflect.DeepEqual(prefix, tt.wantPrefix) {
+				t.Errorf("got %q %q, want %q %q", bin, prefix, tt.wantBin, tt.wantPrefix)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("ran %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDetectComposeCommandOverride(t *testing.T) {
+	// The test binary stands in for a compose command found on PATH
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", filepath.Dir(executable))
+	name := filepath.Base(executable)
+
+	var calls []string
+	run := fakeRunner(map[string]string{"docker compose version": "Docker Compose version v2.29.1"}, &calls)
+
+	bin, prefix, err := detectComposeCommand(name+` compose --namespace "k8s.io"`, run)
+	if err != nil {
+		t.Fatalf("detectComposeCommand: %v", err)
+	}
+	if want := []string{"compose", "--namespace", "k8s.io"}; bin != name || !reflect.DeepEqual(prefix, want) {
+		t.Errorf("got %q %q, want %q %q", bin, prefix, name, want)
+	}
+	if len(calls) != 0 {
+		t.Errorf("ran %q, want no detection with an override", calls)
+	}
+
+	if _, _, err := detectComposeCommand("no-such-compose-binary compose", run); err == nil || !strings.Contains(err.Error(), "compose command not found") {
+		t.Errorf("error = %v, want compose command not found", err)
+	}
+	if _, _, err := detectComposeCommand(`nerdctl "compose`, run); err == nil || !strings.Contains(err.Error(), "invalid compose command") {
+		t.Errorf("error = %v, want invalid compose command", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("ran %q, want no fallback to detection when the override fails", calls)
+	}
+}
+
+func TestComposeCommandLineArgumentOrder(t *testing.T) {
+	composeCommand.once.Do(func() {})
+	defer func(bin string, prefix []string, err error) {
+		composeCommand.bin, composeCommand.prefix, composeCommand.err = bin, prefix, err
+	}(composeCommand.bin, composeCommand.prefix, composeCommand.err)
+	composeCommand.bin, composeCommand.prefix, composeCommand.err = "nerdctl", []string{"compose", "--namespace", "dev"}, nil
+
+	cmdArgs := commandArgs{
+		projectDirectory: "/srv/shop",
+		envFiles:         []string{"a.env", "b.env"},
+		profiles:         []string{"debug", "metrics"},
+		cmdOptions:       []string{"--tail", "10", "web"},
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		args := append(composeFileArgs([]string{"compose.yaml", "compose.dev.yaml"}), passthroughComposeArgs("shop", "logs", cmdArgs)...)
+		bin, got, err := composeCommandLine(args)
+		if err != nil {
+			t.Fatalf("composeCommandLine: %v", err)
+		}
+		want := []string{
+			"compose", "--namespace", "dev",
+			"-f", "compose.yaml", "-f", "compose.dev.yaml",
+			"-p", "shop",
+			"--project-directory", "/srv/shop",
+			"--env-file", "a.env", "--env-file", "b.env",
+			"--profile", "debug", "--profile", "metrics",
+			"logs", "--tail", "10", "web",
+		}
+		if bin != "nerdctl" || !reflect.DeepEqual(got, want) {
+			t.Errorf("command line = %s %q, want nerdctl %q", bin, got, want)
+		}
+	})
+
+	// The rendered project already has the env files applied, so they are not passed again
+	t.Run("filtered", func(t *testing.T) {
+		project := &types.Project{Name: "shop", WorkingDir: "/srv/shop", Profiles: []string{"debug", "metrics"}}
+		bin, got, err := composeCommandLine(filteredComposeArgs(project, "-", "logs", cmdArgs))
+		if err != nil {
+			t.Fatalf("composeCommandLine: %v", err)
+		}
+		want := []string{
+			"compose", "--namespace", "dev",
+			"-f", "-",
+			"--project-directory", "/srv/shop",
+			"-p", "shop",
+			"--profile", "debug", "--profile", "metrics",
+			"logs", "--tail", "10", "web",
+		}
+		if bin != "nerdctl" || !reflect.DeepEqual(got, want) {
+			t.Errorf("command line = %s %q, want nerdctl %q", bin, got, want)
+		}
+	})
+}
+
+func TestExpandPortTemplates(t *testing.T) {
+	environment := types.Mapping{"WEB_PORT": "8081", "BIND": "127.0.0.1", "EMPTY": ""}
+
+	tests := []struct {
+		template string
+		want     []quay.PortMapping
+		wantErr  string
+	}{
+		{template: "web:${WEB_PORT}:80", want: []quay.PortMapping{{ServiceName: "web", HostPort: "8081", ContainerPort: "80", Protocol: "tcp"}}},
+		{template: "web:$WEB_PORT:80/udp", want: []quay.PortMapping{{ServiceName: "web", HostPort: "8081", ContainerPort: "80", Protocol: "udp"}}},
+		{template: "web:${BIND}:${WEB_PORT}:80", want: []quay.PortMapping{{ServiceName: "web", HostIP: "127.0.0.1", HostPort: "8081", ContainerPort: "80", Protocol: "tcp"}}},
+		{template: "db:${DB_PORT:-5433}:5432", want: []quay.PortMapping{{ServiceName: "db", HostPort: "5433", ContainerPort: "5432", Protocol: "tcp"}}},
+		{template: "db:${EMPTY:-5434}:5432", want: []quay.PortMapping{{ServiceName: "db", HostPort: "5434", ContainerPort: "5432", Protocol: "tcp"}}},
+		{template: "db:${DB_PORT}:5432", wantErr: "invalid port mapping 'db:${DB_PORT}:5432': variable DB_PORT is not set"},
+		{template: "db:${DB_PORT:?set DB_PORT}:5432", wantErr: "set DB_PORT"},
+		{template: "web:${WEB_PORT}:http", wantErr: "expanded to 'web:8081:http'"},
+	}
+
+	for _, tt := range tests {
+		got, err := expandPortTemplates([]string{tt.template}, environment)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandPortTemplates(%q) error = %v, want it to contain %q", tt.template, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandPortTemplates(%q): unexpected error: %v", tt.template, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandPortTemplates(%q) = %+v, want %+v", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestApplyOverrideFiles(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	dir := t.TempDir()
+	composePath := writeComposeFile(t, dir, `name: overrides
+services:
+  web:
+    image: nginx:1.27
+    environment:
+      LOG_LEVEL: info
+      MODE: production
+  db:
+    image: postgres
+`)
+	writeOverride := func(name, content string) string {
+		overridePath := filepath.Join(dir, name)
+		if err := os.WriteFile(overridePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return overridePath
+	}
+	debug := writeOverride("debug.yaml", `services:
+  web:
+    image: nginx:1.27-debug
+    environment:
+      LOG_LEVEL: debug
+  db:
+    environment:
+      POSTGRES_PASSWORD: secret
+`)
+	local := writeOverride("local.yaml", `services:
+  web:
+    environment:
+      LOG_LEVEL: trace
+    command: ["nginx-debug", "-g", "daemon off;"]
+  cache:
+    image: redis
+`)
+
+	cmdArgs, err := parseRemainingArgs("up", []string{"--include", "web"})
+	if err != nil {
+		t.Fatalf("parseRemainingArgs: %v", err)
+	}
+	project, err := loadFilteredProject([]string{composePath}, cmdArgs)
+	if err != nil {
+		t.Fatalf("loadFilteredProject: %v", err)
+	}
+
+	merged, missingServices, err := applyOverrideFiles(project, []string{debug, local})
+	if err != nil {
+		t.Fatalf("applyOverrideFiles: %v", err)
+	}
+	if want := []string{"cache", "db"}; !reflect.DeepEqual(missingServices, want) {
+		t.Errorf("missing services = %q, want %q", missingServices, want)
+	}
+	if want := []string{"web"}; !reflect.DeepEqual(merged.ServiceNames(), want) {
+		t.Errorf("services = %q, want %q", merged.ServiceNames(), want)
+	}
+
+	web := merged.Services["web"]
+	if web.Image != "nginx:1.27-debug" {
+		t.Errorf("image = %q, want the one of the first override", web.Image)
+	}
+	if want := (types.ShellCommand{"nginx-debug", "-g", "daemon off;"}); !reflect.DeepEqual(web.Command, want) {
+		t.Errorf("command = %q, want %q", web.Command, want)
+	}
+	for key, want := range map[string]string{"LOG_LEVEL": "trace", "MODE": "production"} {
+		if value := web.Environment[key]; value == nil || *value != want {
+			t.Errorf("%s = %v, want %q", key, value, want)
+		}
+	}
+
+	if got, _, err := applyOverrideFiles(project, nil); err != nil || got != project {
+		t.Errorf("without override files got %p, %v, want the project unchanged", got, err)
+	}
+	invalid := writeOverride("invalid.yaml", "services: [web\n")
+	if _, _, err := applyOverrideFiles(project, []string{invalid}); err == nil || !strings.Contains(err.Error(), "parsing override file") {
+		t.Errorf("error = %v, want a parsing error", err)
+	}
+	if _, _, err := applyOverrideFiles(project, []string{filepath.Join(dir, "missing.yaml")}); err == nil || !strings.Contains(err.Error(), "reading override file") {
+		t.Errorf("error = %v, want a reading error", err)
+	}
+}
+
+func TestApplyScales(t *testing.T) {
+	one := 1
+	newProject := func() *types.Project {
+		return &types.Project{Services: types.Services{
+			"worker":    {Name: "worker", Deploy: &types.DeployConfig{Replicas: &one, Labels: types.Labels{"team": "jobs"}}},
+			"scaled":    {Name: "scaled", Scale: &one},
+			"web":       {Name: "web"},
+			"scheduler": {Name: "scheduler", ContainerName: "scheduler"},
+		}}
+	}
+
+	project := newProject()
+	original := project.Services["worker"].Deploy
+	missingServices, err := applyScales(project, []ServiceScale{
+		{ServiceName: "worker", Replicas: 3},
+		{ServiceName: "scaled", Replicas: 2},
+		{ServiceName: "web", Replicas: 0},
+		{ServiceName: "scheduler", Replicas: 1},
+		{ServiceName: "cache", Replicas: 2},
+	})
+	if err != nil {
+		t.Fatalf("applyScales: %v", err)
+	}
+	if want := []string{"cache"}; !reflect.DeepEqual(missingServices, want) {
+		t.Errorf("missing services = %q, want %q", missingServices, want)
+	}
+
+	for name, want := range map[string]int{"worker": 3, "scaled": 2, "web": 0, "scheduler": 1} {
+		deploy := project.Services[name].Deploy
+		if deploy == nil || deploy.Replicas == nil || *deploy.Replicas != want {
+			t.Errorf("%s replicas = %v, want %d", name, deploy, want)
+		}
+	}
+	if scale := project.Services["scaled"].Scale; scale == nil || *scale != 2 {
+		t.Errorf("scaled scale = %v, want it kept in sync with deploy.replicas", scale)
+	}
+	if project.Services["web"].Scale != nil {
+		t.Error("web got a scale it didn't have")
+	}
+	if labels := project.Services["worker"].Deploy.Labels; labels["team"] != "jobs" {
+		t.Errorf("worker deploy labels = %v, want them kept", labels)
+	}
+	if *original.Replicas != 1 {
+		t.Errorf("scaling changed the deploy config of the unfiltered project to %d replicas", *original.Replicas)
+	}
+
+	_, err = applyScales(newProject(), []ServiceScale{{ServiceName: "scheduler", Replicas: 2}})
+	if want := "cannot scale service scheduler to 2: it sets container_name scheduler"; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestApplyRegistry(t *testing.T) {
+	newProject := func() *types.Project {
+		return &types.Project{Services: types.Services{
+			"web":   {Name: "web", Image: "nginx:1.27"},
+			"api":   {Name: "api", Image: "ghcr.io/acme/api:2"},
+			"cache": {Name: "cache", Image: "localhost:5000/redis"},
+			"app":   {Name: "app", Build: &types.BuildConfig{Context: "."}},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		prefix  string
+		replace bool
+		want    map[string]string
+	}{
+		{
+			name:   "prefix",
+			prefix: "mirror.example.com/proxy",
+			want: map[string]string{
+				"web":   "mirror.example.com/proxy/nginx:1.27",
+				"api":   "mirror.example.com/proxy/ghcr.io/acme/api:2",
+				"cache": "mirror.example.com/proxy/localhost:5000/redis",
+				"app":   "",
+			},
+		},
+		{
+			name:    "replace",
+			prefix:  "mirror.example.com",
+			replace: true,
+			want: map[string]string{
+				"web":   "mirror.example.com/nginx:1.27",
+				"api":   "mirror.example.com/acme/api:2",
+				"cache": "mirror.example.com/redis",
+				"app":   "",
+			},
+		},
+		{
+			name: "no prefix",
+			want: map[string]string{
+				"web":   "nginx:1.27",
+				"api":   "ghcr.io/acme/api:2",
+				"cache": "localhost:5000/redis",
+				"app":   "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := newProject()
+			applyRegistry(project, tt.prefix, tt.replace)
+			for name, want := range tt.want {
+				if got := project.Services[name].Image; got != want {
+					t.Errorf("%s image = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyImageOverrides(t *testing.T) {
+	newProject := func() *types.Project {
+		return &types.Project{Services: types.Services{
+			"api":    {Name: "api", Image: "acme/api:dev", Build: &types.BuildConfig{Context: "./api"}, PullPolicy: types.PullPolicyBuild},
+			"worker": {Name: "worker", Build: &types.BuildConfig{Context: "./worker"}, PullPolicy: types.PullPolicyAlways},
+			"db":     {Name: "db", Image: "postgres:16"},
+		}}
+	}
+	images := []ImageOverride{
+		{ServiceName: "api", Image: "acme/api:2"},
+		{ServiceName: "worker", Image: "acme/worker:2"},
+		{ServiceName: "db", Image: "postgres:17"},
+		{ServiceName: "cache", Image: "redis"},
+	}
+
+	project := newProject()
+	missingServices := applyImageOverrides(project, images, false)
+	if want := []string{"cache"}; !reflect.DeepEqual(missingServices, want) {
+		t.Errorf("missing services = %q, want %q", missingServices, want)
+	}
+	for name, want := range map[string]string{"api": "acme/api:2", "worker": "acme/worker:2", "db": "postgres:17"} {
+		if got := project.Services[name].Image; got != want {
+			t.Errorf("%s image = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"api", "worker"} {
+		if project.Services[name].Build != nil {
+			t.Errorf("%s still builds", name)
+		}
+	}
+	if got := project.Services["api"].PullPolicy; got != "" {
+		t.Errorf("api pull policy = %q, want the build policy dropped", got)
+	}
+	if got := project.Services["worker"].PullPolicy; got != types.PullPolicyAlways {
+		t.Errorf("worker pull policy = %q, want %q", got, types.PullPolicyAlways)
+	}
+
+	project = newProject()
+	applyImageOverrides(project, images, true)
+	api := project.Services["api"]
+	if api.Image != "acme/api:2" || api.Build == nil || api.PullPolicy != types.PullPolicyBuild {
+		t.Errorf("api = image %q, build %v, pull policy %q, want the build kept with --keep-build", api.Image, api.Build, api.PullPolicy)
+	}
+}
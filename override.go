@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// applyOverrideFiles merges compose file fragments into the filtered project with the merge
+// rules of compose-go, as if they were given with -f after the compose files, and returns the
+// merged project along with the services the fragments configure that are not part of it.
+// Those services are left out of the merge, since a fragment rarely defines a complete service
+func applyOverrideFiles(project *types.Project, overridePaths []string) (*types.Project, []string, error) {
+	if len(overridePaths) == 0 {
+		return project, nil, nil
+	}
+
+	var missingServices []string
+	var overrides []types.ConfigFile
+	for _, overridePath := range overridePaths {
+		data, err := os.ReadFile(overridePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading override file: %w", err)
+		}
+
+		var config map[string]any
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, nil, fmt.Errorf("parsing override file %s: %w", overridePath, err)
+		}
+
+		if services, ok := config["services"].(map[string]any); ok {
+			for name := range services {
+				if _, exists := project.Services[name]; !exists {
+					missingServices = append(missingServices, name)
+					delete(services, name)
+				}
+			}
+		}
+
+		overrides = append(overrides, types.ConfigFile{Filename: overridePath, Config: config})
+	}
+
+	yamlData, err := marshalProject(project)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged, err := loadRenderedProject(project, yamlData, overrides...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("applying override files: %w", err)
+	}
+
+	sort.Strings(missingServices)
+	return merged, missingServices, nil
+}
@@ -0,0 +1,17 @@
+// Package quay filters Docker Compose projects loaded with compose-go, the way the quay
+// command does before handing them to Docker Compose.
+//
+// FilterProject keeps the services selected by name, glob pattern, regular expression or
+// label, along with the services they cannot be created without:
+//
+//	filtered, missing, err := quay.FilterProject(project, quay.FilterOptions{
+//		IncludeServices: []string{"web", "worker-*"},
+//		WithDeps:        true,
+//	})
+//
+// ParsePortMapping and ApplyPortMappings then change the ports the remaining services
+// publish:
+//
+//	mappings, err := quay.ParsePortMapping("web:8080:80")
+//	missing, err := quay.ApplyPortMappings(filtered, mappings, nil)
+package quay
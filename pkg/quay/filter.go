@@ -0,0 +1,497 @@
+package quay
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// FilterOptions describes which services of a project FilterProject and SelectServices keep.
+// Include options (IncludeServices, IncludeRegexps, IncludeLabels and Selectors) and exclude
+// options (ExcludeServices, ExcludeRegexps and ExcludeLabels) are not meant to be combined:
+// as soon as an include option is set, the exclude options are ignored
+type FilterOptions struct {
+	// IncludeServices and ExcludeServices are service names or shell-style glob patterns.
+	// Literal values that name no service also match services by container_name
+	IncludeServices []string
+	ExcludeServices []string
+	// IncludeRegexps and ExcludeRegexps are regular expressions matching whole service names
+	IncludeRegexps []string
+	ExcludeRegexps []string
+	// IncludeLabels and ExcludeLabels are KEY=VALUE labels, any of which selects a service
+	IncludeLabels []string
+	ExcludeLabels []string
+	// Selectors are KEY=VALUE or KEY!=VALUE label conditions that a service has to meet all of
+	Selectors []string
+	// WithDeps also includes the services included services depend on, and WithDependents
+	// the services that depend on included services
+	WithDeps       bool
+	WithDependents bool
+	// FailOnRequired reports an error instead of keeping services that selected services
+	// reference through network_mode, ipc, pid, volumes_from or links
+	FailOnRequired bool
+}
+
+// IncludeMode reports whether services are selected explicitly by name, regular expression, label or selector
+func (o FilterOptions) IncludeMode() bool {
+	return len(o.IncludeServices) > 0 || len(o.IncludeRegexps) > 0 || len(o.IncludeLabels) > 0 || len(o.Selectors) > 0
+}
+
+// ExcludeMode reports whether services are removed by name, regular expression or label
+func (o FilterOptions) ExcludeMode() bool {
+	return len(o.ExcludeServices) > 0 || len(o.ExcludeRegexps) > 0 || len(o.ExcludeLabels) > 0
+}
+
+// Selection is the result of SelectServices
+type Selection struct {
+	// Project is the filtered project
+	Project *types.Project
+	// Missing lists the requested services and patterns that matched no service, along with
+	// descriptions of regular expressions, labels and selectors that matched none, sorted
+	Missing []string
+	// Dependencies and Dependents are the services added by WithDeps and WithDependents
+	Dependencies []string
+	Dependents   []string
+	// Required are the services kept because selected services cannot be created without them
+	Required []string
+	// ContainerNames are the values that named no service but matched a container_name,
+	// formatted as "value -> service"
+	ContainerNames []string
+}
+
+// FilterProject returns a copy of the project that only contains the services opts select,
+// along with the requested services, patterns and selectors that matched nothing. depends_on
+// entries referencing services that were filtered out are dropped, so the result can be
+// handed to Docker Compose as is. Use SelectServices to learn which services were added on
+// top of the selection, or to keep such depends_on entries
+func FilterProject(project *types.Project, opts FilterOptions) (*types.Project, []string, error) {
+	selection, err := SelectServices(project, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	RemoveDanglingDependencies(selection.Project)
+	return selection.Project, selection.Missing, nil
+}
+
+// SelectServices filters a project down to the services opts select. Include options keep
+// only the services they match, while exclude options keep every other service. Services
+// the selection cannot be created without, referenced through network_mode, ipc, pid,
+// volumes_from or links, are kept as well, unless FailOnRequired is set, in which case an
+// error naming the offending references is returned.
+//
+// The returned project shares everything but its services map with the given one. Its
+// depends_on entries may still reference services that were filtered out; see
+// RemoveDanglingDependencies
+func SelectServices(project *types.Project, opts FilterOptions) (Selection, error) {
+	// Track which selectors we couldn't match. Service values may be literal names
+	// or glob patterns, and a selector counts as found once it matches any service
+	missingServiceSelectors := make(map[string]bool)
+	for _, service := range opts.IncludeServices {
+		missingServiceSelectors[service] = true
+	}
+	for _, service := range opts.ExcludeServices {
+		missingServiceSelectors[service] = true
+	}
+
+	missingRegexpSelectors := make(map[string]bool)
+	for _, selector := range opts.IncludeRegexps {
+		missingRegexpSelectors[selector] = true
+	}
+	for _, selector := range opts.ExcludeRegexps {
+		missingRegexpSelectors[selector] = true
+	}
+
+	includeRegexps, err := CompileNameRegexps(opts.IncludeRegexps)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	excludeRegexps, err := CompileNameRegexps(opts.ExcludeRegexps)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	missingLabelSelectors := make(map[string]bool)
+	for _, selector := range opts.IncludeLabels {
+		missingLabelSelectors[selector] = true
+	}
+	for _, selector := range opts.ExcludeLabels {
+		missingLabelSelectors[selector] = true
+	}
+
+	// Create a filtered version of the project services
+	filteredServices := types.Services{}
+
+	// If include selectors are specified, only include the services they match
+	// If only exclude selectors are specified, include all except those
+	usingIncludeMode := opts.IncludeMode()
+	selectorsMatched := false
+
+	// Values that name no service may still name a container
+	serviceValues := opts.ExcludeServices
+	if usingIncludeMode {
+		serviceValues = opts.IncludeServices
+	}
+	byContainerName := containerNameMatches(project, serviceValues)
+
+	for name, service := range project.Services {
+		var matchedNames, matchedRegexps, matchedLabels []string
+		if usingIncludeMode {
+			// Include mode: only add services matched by name, regular expression or label
+			matchedNames = append(MatchingPatterns(name, opts.IncludeServices), byContainerName[name]...)
+			matchedRegexps = matchingRegexps(name, opts.IncludeRegexps, includeRegexps)
+			matchedLabels = matchingLabels(service.Labels, opts.IncludeLabels)
+			selected := len(opts.Selectors) > 0 && matchesAllSelectors(service.Labels, opts.Selectors)
+			if selected {
+				selectorsMatched = true
+			}
+			if len(matchedNames) > 0 || len(matchedRegexps) > 0 || len(matchedLabels) > 0 || selected {
+				filteredServices[name] = service
+			}
+		} else {
+			// Exclude mode: add all services except those matched by name, regular expression or label
+			matchedNames = append(MatchingPatterns(name, opts.ExcludeServices), byContainerName[name]...)
+			matchedRegexps = matchingRegexps(name, opts.ExcludeRegexps, excludeRegexps)
+			matchedLabels = matchingLabels(service.Labels, opts.ExcludeLabels)
+			if len(matchedNames) == 0 && len(matchedRegexps) == 0 && len(matchedLabels) == 0 {
+				filteredServices[name] = service
+			}
+		}
+
+		for _, pattern := range matchedNames {
+			delete(missingServiceSelectors, pattern)
+		}
+		for _, selector := range matchedRegexps {
+			delete(missingRegexpSelectors, selector)
+		}
+		for _, selector := range matchedLabels {
+			delete(missingLabelSelectors, selector)
+		}
+	}
+
+	// Pull in dependents and dependencies of explicitly included services. These are never
+	// reported as missing since they were not requested by name. Dependents go first so
+	// their own dependencies are satisfied as well
+	var selection Selection
+	for name, values := range byContainerName {
+		for _, value := range values {
+			selection.ContainerNames = append(selection.ContainerNames, fmt.Sprintf("%s -> %s", value, name))
+		}
+	}
+	sort.Strings(selection.ContainerNames)
+
+	if usingIncludeMode && opts.WithDependents {
+		selection.Dependents = addReachableServices(project, filteredServices, dependentGraph(project))
+	}
+	if usingIncludeMode && opts.WithDeps {
+		selection.Dependencies = addReachableServices(project, filteredServices, dependencyGraph(project))
+	}
+
+	// Services referenced through network_mode, ipc, pid, volumes_from or links must exist
+	// for compose to accept the configuration, whichever way the selection was made
+	if opts.FailOnRequired {
+		if edges := unsatisfiedReferences(project, filteredServices); len(edges) > 0 {
+			return Selection{}, fmt.Errorf("selected services require services that were filtered out: %s", strings.Join(edges, ", "))
+		}
+	} else {
+		selection.Required = addReachableServices(project, filteredServices, requiredGraph(project))
+	}
+
+	// Collect missing services for error reporting
+	var missingServices []string
+	for service := range missingServiceSelectors {
+		missingServices = append(missingServices, service)
+	}
+	for selector := range missingRegexpSelectors {
+		missingServices = append(missingServices, fmt.Sprintf("regex %s matched no services", selector))
+	}
+	for selector := range missingLabelSelectors {
+		missingServices = append(missingServices, fmt.Sprintf("label %s matched no services", selector))
+	}
+	if len(opts.Selectors) > 0 && !selectorsMatched {
+		missingServices = append(missingServices, fmt.Sprintf("selector %s matched no services", strings.Join(opts.Selectors, ",")))
+	}
+	sort.Strings(missingServices)
+
+	// Create a filtered project with the selected services
+	filteredProject := *project
+	filteredProject.Services = filteredServices
+	selection.Project = &filteredProject
+	selection.Missing = missingServices
+
+	return selection, nil
+}
+
+// containerNameMatches maps service names to the values that select them by container_name.
+// Only literal values that are not a service name are considered, so service names always
+// take precedence. A value shared by several container names matches all of those services
+func containerNameMatches(project *types.Project, values []string) map[string][]string {
+	matches := make(map[string][]string)
+	for _, value := range values {
+		if IsGlobPattern(value) {
+			continue
+		}
+		if _, exists := project.Services[value]; exists {
+			continue
+		}
+		for name, service := range project.Services {
+			if service.ContainerName == value {
+				matches[name] = append(matches[name], value)
+			}
+		}
+	}
+	return matches
+}
+
+// CompileNameRegexps compiles regular expressions that have to match whole service names
+func CompileNameRegexps(sources []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, source := range sources {
+		re, err := regexp.Compile("^(?:" + source + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid service regex '%s': %w", source, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchingRegexps returns the sources of the compiled regular expressions that match the given service name
+func matchingRegexps(name string, sources []string, compiled []*regexp.Regexp) []string {
+	var matched []string
+	for i, re := range compiled {
+		if re.MatchString(name) {
+			matched = append(matched, sources[i])
+		}
+	}
+	return matched
+}
+
+// matchingLabels returns the KEY=VALUE label selectors that match the given service labels
+func matchingLabels(labels types.Labels, selectors []string) []string {
+	var matched []string
+	for _, selector := range selectors {
+		key, value, _ := strings.Cut(selector, "=")
+		if actual, exists := labels[key]; exists && actual == value {
+			matched = append(matched, selector)
+		}
+	}
+	return matched
+}
+
+// matchesAllSelectors reports whether labels satisfy every selector, where KEY=VALUE requires
+// the label to have that value and KEY!=VALUE requires it to be missing or have another value
+func matchesAllSelectors(labels types.Labels, selectors []string) bool {
+	for _, selector := range selectors {
+		if key, value, negated := strings.Cut(selector, "!="); negated {
+			if actual, exists := labels[key]; exists && actual == value {
+				return false
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(selector, "=")
+		if actual, exists := labels[key]; !exists || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// IsGlobPattern reports whether an include/exclude value contains shell-style glob metacharacters
+func IsGlobPattern(value string) bool {
+	return strings.ContainsAny(value, "*?[")
+}
+
+// MatchingPatterns returns the include/exclude values that select the given service name.
+// A value containing glob metacharacters is matched with path.Match, anything else must
+// equal the service name exactly
+func MatchingPatterns(name string, patterns []string) []string {
+	var matched []string
+	for _, pattern := range patterns {
+		if !IsGlobPattern(pattern) {
+			if pattern == name {
+				matched = append(matched, pattern)
+			}
+			continue
+		}
+
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			matched = append(matched, pattern)
+		}
+	}
+	return matched
+}
+
+// RemoveDanglingDependencies rewrites the depends_on entries of every service in the project
+// so they only reference services that are still present, and returns the dropped edges
+// formatted as "service -> dependency"
+func RemoveDanglingDependencies(project *types.Project) []string {
+	var droppedEdges []string
+
+	for name, service := range project.Services {
+		var dependsOn types.DependsOnConfig
+		for dependency, config := range service.DependsOn {
+			if _, exists := project.Services[dependency]; !exists {
+				droppedEdges = append(droppedEdges, fmt.Sprintf("%s -> %s", name, dependency))
+				continue
+			}
+			if dependsOn == nil {
+				dependsOn = types.DependsOnConfig{}
+			}
+			dependsOn[dependency] = config
+		}
+
+		// The map is shared with the unfiltered project, so replace it rather than mutate it
+		if len(dependsOn) != len(service.DependsOn) {
+			service.DependsOn = dependsOn
+			project.Services[name] = service
+		}
+	}
+
+	sort.Strings(droppedEdges)
+	return droppedEdges
+}
+
+// serviceReference is a reference from one service to another, along with the
+// compose attribute that declares it
+type serviceReference struct {
+	service string
+	kind    string
+}
+
+// requiredReferences returns the services a service cannot be created without: the targets
+// of service: network, ipc and pid modes, of volumes_from and of legacy links
+func requiredReferences(service types.ServiceConfig) []serviceReference {
+	var references []serviceReference
+
+	modes := []struct{ kind, value string }{
+		{"network_mode", service.NetworkMode},
+		{"ipc", service.Ipc},
+		{"pid", service.Pid},
+	}
+	for _, mode := range modes {
+		if name, found := strings.CutPrefix(mode.value, "service:"); found {
+			references = append(references, serviceReference{service: name, kind: mode.kind})
+		}
+	}
+
+	for _, volumesFrom := range service.VolumesFrom {
+		// Entries look like SERVICE[:ro|rw] or container:NAME[:ro|rw], the latter not being a service
+		if strings.HasPrefix(volumesFrom, "container:") {
+			continue
+		}
+		name, _, _ := strings.Cut(volumesFrom, ":")
+		references = append(references, serviceReference{service: name, kind: "volumes_from"})
+	}
+
+	for _, link := range service.Links {
+		name, _, _ := strings.Cut(link, ":")
+		references = append(references, serviceReference{service: name, kind: "links"})
+	}
+
+	return references
+}
+
+// serviceReferences returns the names of the services a service relies on through
+// depends_on or any of its required references
+func serviceReferences(service types.ServiceConfig) []string {
+	var references []string
+	for dependency := range service.DependsOn {
+		references = append(references, dependency)
+	}
+
+	for _, reference := range requiredReferences(service) {
+		references = append(references, reference.service)
+	}
+
+	return references
+}
+
+// requiredGraph maps every service to the services it cannot be created without
+func requiredGraph(project *types.Project) map[string][]string {
+	graph := make(map[string][]string)
+	for name, service := range project.Services {
+		for _, reference := range requiredReferences(service) {
+			graph[name] = append(graph[name], reference.service)
+		}
+	}
+	return graph
+}
+
+// unsatisfiedReferences lists the required references of the selected services that point to
+// project services outside the selection, formatted as "service -> reference (kind)"
+func unsatisfiedReferences(project *types.Project, selected types.Services) []string {
+	var edges []string
+	for name, service := range selected {
+		for _, reference := range requiredReferences(service) {
+			_, isSelected := selected[reference.service]
+			_, exists := project.Services[reference.service]
+			if !isSelected && exists {
+				edges = append(edges, fmt.Sprintf("%s -> %s (%s)", name, reference.service, reference.kind))
+			}
+		}
+	}
+
+	sort.Strings(edges)
+	return edges
+}
+
+// dependencyGraph maps every service to the services it references
+func dependencyGraph(project *types.Project) map[string][]string {
+	graph := make(map[string][]string)
+	for name, service := range project.Services {
+		graph[name] = serviceReferences(service)
+	}
+	return graph
+}
+
+// dependentGraph maps every service to the services that reference it
+func dependentGraph(project *types.Project) map[string][]string {
+	graph := make(map[string][]string)
+	for name, service := range project.Services {
+		for _, reference := range serviceReferences(service) {
+			graph[reference] = append(graph[reference], name)
+		}
+	}
+	return graph
+}
+
+// addReachableServices extends the selected services with every project service reachable
+// from them through the graph and returns the sorted names of the services it added.
+// Each service is visited at most once, so circular references terminate
+func addReachableServices(project *types.Project, selected types.Services, graph map[string][]string) []string {
+	var added []string
+
+	queue := make([]string, 0, len(selected))
+	for name := range selected {
+		queue = append(queue, name)
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		for _, next := range graph[name] {
+			if _, seen := selected[next]; seen {
+				continue
+			}
+
+			service, exists := project.Services[next]
+			if !exists {
+				continue
+			}
+
+			selected[next] = service
+			added = append(added, next)
+			queue = append(queue, next)
+		}
+	}
+
+	sort.Strings(added)
+	return added
+}
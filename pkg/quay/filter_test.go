@@ -0,0 +1,399 @@
+package quay
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// testProject returns a project with a web frontend, an api backend and their backing services
+func testProject() *types.Project {
+	return &types.Project{
+		Name: "test",
+		Services: types.Services{
+			"web": {
+				Name:      "web",
+				Labels:    types.Labels{"tier": "frontend"},
+				DependsOn: types.DependsOnConfig{"api": {Condition: types.ServiceConditionStarted}},
+			},
+			"api": {
+				Name:          "api",
+				ContainerName: "backend-api",
+				Labels:        types.Labels{"tier": "backend", "lang": "go"},
+				DependsOn: types.DependsOnConfig{
+					"db":    {Condition: types.ServiceConditionHealthy},
+					"cache": {Condition: types.ServiceConditionStarted},
+				},
+			},
+			"worker": {
+				Name:      "worker",
+				Labels:    types.Labels{"tier": "backend", "lang": "python"},
+				DependsOn: types.DependsOnConfig{"db": {Condition: types.ServiceConditionStarted}},
+			},
+			"db":    {Name: "db", Labels: types.Labels{"tier": "data"}},
+			"cache": {Name: "cache", Labels: types.Labels{"tier": "data"}},
+		},
+	}
+}
+
+// serviceNames returns the sorted names of the services of a project
+func serviceNames(project *types.Project) []string {
+	var names []string
+	for name := range project.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sorted returns a sorted copy of values, with nil for an empty slice
+func sorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	values = append([]string(nil), values...)
+	sort.Strings(values)
+	return values
+}
+
+func TestFilterProject(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         FilterOptions
+		wantServices []string
+		wantMissing  []string
+		wantErr      string
+	}{
+		{
+			name:         "nothing selected keeps every service",
+			wantServices: []string{"api", "cache", "db", "web", "worker"},
+		},
+		{
+			name:         "include by name",
+			opts:         FilterOptions{IncludeServices: []string{"web", "db"}},
+			wantServices: []string{"db", "web"},
+		},
+		{
+			name:         "exclude by name",
+			opts:         FilterOptions{ExcludeServices: []string{"web", "worker"}},
+			wantServices: []string{"api", "cache", "db"},
+		},
+		{
+			name:         "include ignores exclude",
+			opts:         FilterOptions{IncludeServices: []string{"web"}, ExcludeServices: []string{"web"}},
+			wantServices: []string{"web"},
+		},
+		{
+			name:         "missing services are reported",
+			opts:         FilterOptions{IncludeServices: []string{"web", "proxy"}},
+			wantServices: []string{"web"},
+			wantMissing:  []string{"proxy"},
+		},
+		{
+			name:         "missing services are reported in order",
+			opts:         FilterOptions{IncludeServices: []string{"zeta", "web", "proxy", "alpha"}},
+			wantServices: []string{"web"},
+			wantMissing:  []string{"alpha", "proxy", "zeta"},
+		},
+		{
+			name:         "include by glob",
+			opts:         FilterOptions{IncludeServices: []string{"w*"}},
+			wantServices: []string{"web", "worker"},
+		},
+		{
+			name:         "exclude by glob",
+			opts:         FilterOptions{ExcludeServices: []string{"[cd]*"}},
+			wantServices: []string{"api", "web", "worker"},
+		},
+		{
+			name:         "unmatched glob is reported",
+			opts:         FilterOptions{IncludeServices: []string{"proxy-*", "db"}},
+			wantServices: []string{"db"},
+			wantMissing:  []string{"proxy-*"},
+		},
+		{
+			name:         "include by container name",
+			opts:         FilterOptions{IncludeServices: []string{"backend-api"}},
+			wantServices: []string{"api"},
+		},
+		{
+			name:         "include by regex matches whole names",
+			opts:         FilterOptions{IncludeRegexps: []string{"w.b", "ap"}},
+			wantServices: []string{"web"},
+			wantMissing:  []string{"regex ap matched no services"},
+		},
+		{
+			name:         "missing names and patterns are reported in order",
+			opts:         FilterOptions{IncludeServices: []string{"web", "proxy"}, IncludeRegexps: []string{"ap", "cach"}},
+			wantServices: []string{"web"},
+			wantMissing:  []string{"proxy", "regex ap matched no services", "regex cach matched no services"},
+		},
+		{
+			name:         "exclude by regex",
+			opts:         FilterOptions{ExcludeRegexps: []string{"(db|cache)"}},
+			wantServices: []string{"api", "web", "worker"},
+		},
+		{
+			name:    "invalid regex",
+			opts:    FilterOptions{IncludeRegexps: []string{"("}},
+			wantErr: "invalid service regex '('",
+		},
+		{
+			name:         "include by label",
+			opts:         FilterOptions{IncludeLabels: []string{"tier=backend"}},
+			wantServices: []string{"api", "worker"},
+		},
+		{
+			name:         "exclude by label",
+			opts:         FilterOptions{ExcludeLabels: []string{"tier=data", "tier=nothing"}},
+			wantServices: []string{"api", "web", "worker"},
+			wantMissing:  []string{"label tier=nothing matched no services"},
+		},
+		{
+			name:         "selectors must all match",
+			opts:         FilterOptions{Selectors: []string{"tier=backend", "lang!=python"}},
+			wantServices: []string{"api"},
+		},
+		{
+			name:         "negated selector matches services without the label",
+			opts:         FilterOptions{Selectors: []string{"lang!=go"}},
+			wantServices: []string{"cache", "db", "web", "worker"},
+		},
+		{
+			name:        "unmatched selectors are reported",
+			opts:        FilterOptions{Selectors: []string{"tier=backend", "lang=rust"}},
+			wantMissing: []string{"selector tier=backend,lang=rust matched no services"},
+		},
+		{
+			name:         "with dependencies",
+			opts:         FilterOptions{IncludeServices: []string{"web"}, WithDeps: true},
+			wantServices: []string{"api", "cache", "db", "web"},
+		},
+		{
+			name:         "with dependents",
+			opts:         FilterOptions{IncludeServices: []string{"db"}, WithDependents: true},
+			wantServices: []string{"api", "db", "web", "worker"},
+		},
+		{
+			name:         "with dependents and their dependencies",
+			opts:         FilterOptions{IncludeServices: []string{"db"}, WithDependents: true, WithDeps: true},
+			wantServices: []string{"api", "cache", "db", "web", "worker"},
+		},
+		{
+			name:         "dependencies are not added in exclude mode",
+			opts:         FilterOptions{ExcludeServices: []string{"api"}, WithDeps: true},
+			wantServices: []string{"cache", "db", "web", "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := testProject()
+			filtered, missing, err := FilterProject(project, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := sorted(serviceNames(filtered)); !reflect.DeepEqual(got, tt.wantServices) {
+				t.Errorf("services = %q, want %q", got, tt.wantServices)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %q, want %q", missing, tt.wantMissing)
+			}
+			if len(project.Services) != 5 {
+				t.Errorf("the original project lost services: %q", serviceNames(project))
+			}
+		})
+	}
+}
+
+func TestFilterProjectDropsDanglingDependencies(t *testing.T) {
+	project := testProject()
+	filtered, _, err := FilterProject(project, FilterOptions{IncludeServices: []string{"api", "db"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, exists := filtered.Services["api"].DependsOn["cache"]; exists {
+		t.Errorf("api still depends on the filtered out cache service")
+	}
+	if _, exists := filtered.Services["api"].DependsOn["db"]; !exists {
+		t.Errorf("api no longer depends on db")
+	}
+	if _, exists := project.Services["api"].DependsOn["cache"]; !exists {
+		t.Errorf("the depends_on map of the original project was modified")
+	}
+}
+
+func TestSelectServicesReportsAddedServices(t *testing.T) {
+	project := testProject()
+	selection, err := SelectServices(project, FilterOptions{IncludeServices: []string{"api"}, WithDeps: true, WithDependents: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"web"}; !reflect.DeepEqual(selection.Dependents, want) {
+		t.Errorf("dependents = %q, want %q", selection.Dependents, want)
+	}
+	if want := []string{"cache", "db"}; !reflect.DeepEqual(selection.Dependencies, want) {
+		t.Errorf("dependencies = %q, want %q", selection.Dependencies, want)
+	}
+
+	// SelectServices leaves depends_on untouched
+	selection, err = SelectServices(project, FilterOptions{IncludeServices: []string{"web"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, exists := selection.Project.Services["web"].DependsOn["api"]; !exists {
+		t.Errorf("depends_on of web was rewritten")
+	}
+}
+
+func TestSelectServicesContainerNames(t *testing.T) {
+	selection, err := SelectServices(testProject(), FilterOptions{ExcludeServices: []string{"backend-api"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, exists := selection.Project.Services["api"]; exists {
+		t.Errorf("api was not excluded by its container name")
+	}
+	if want := []string{"backend-api -> api"}; !reflect.DeepEqual(selection.ContainerNames, want) {
+		t.Errorf("container names = %q, want %q", selection.ContainerNames, want)
+	}
+}
+
+func TestSelectServicesCycles(t *testing.T) {
+	project := &types.Project{
+		Name: "cycle",
+		Services: types.Services{
+			"a": {Name: "a", DependsOn: types.DependsOnConfig{"b": {}}},
+			"b": {Name: "b", DependsOn: types.DependsOnConfig{"c": {}}},
+			"c": {Name: "c", DependsOn: types.DependsOnConfig{"a": {}}},
+			"d": {Name: "d"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		opts             FilterOptions
+		wantServices     []string
+		wantDependencies []string
+		wantDependents   []string
+	}{
+		{
+			name:             "dependencies",
+			opts:             FilterOptions{IncludeServices: []string{"a"}, WithDeps: true},
+			wantServices:     []string{"a", "b", "c"},
+			wantDependencies: []string{"b", "c"},
+		},
+		{
+			name:           "dependents",
+			opts:           FilterOptions{IncludeServices: []string{"b"}, WithDependents: true},
+			wantServices:   []string{"a", "b", "c"},
+			wantDependents: []string{"a", "c"},
+		},
+		{
+			name:         "both",
+			opts:         FilterOptions{IncludeServices: []string{"c"}, WithDeps: true, WithDependents: true},
+			wantServices: []string{"a", "b", "c"},
+			// Dependents are added first, so the dependencies are already selected
+			wantDependents: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selection, err := SelectServices(project, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := serviceNames(selection.Project); !reflect.DeepEqual(got, tt.wantServices) {
+				t.Errorf("services = %q, want %q", got, tt.wantServices)
+			}
+			if got := sorted(selection.Dependencies); !reflect.DeepEqual(got, tt.wantDependencies) {
+				t.Errorf("dependencies = %q, want %q", got, tt.wantDependencies)
+			}
+			if got := sorted(selection.Dependents); !reflect.DeepEqual(got, tt.wantDependents) {
+				t.Errorf("dependents = %q, want %q", got, tt.wantDependents)
+			}
+		})
+	}
+}
+
+func TestSelectServicesRequiredReferences(t *testing.T) {
+	project := &types.Project{
+		Name: "required",
+		Services: types.Services{
+			"app":     {Name: "app", NetworkMode: "service:vpn", VolumesFrom: []string{"data:ro", "container:external"}},
+			"vpn":     {Name: "vpn"},
+			"data":    {Name: "data"},
+			"sidecar": {Name: "sidecar", Links: []string{"app:application"}},
+		},
+	}
+
+	selection, err := SelectServices(project, FilterOptions{IncludeServices: []string{"app"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"app", "data", "vpn"}; !reflect.DeepEqual(serviceNames(selection.Project), want) {
+		t.Errorf("services = %q, want %q", serviceNames(selection.Project), want)
+	}
+	if want := []string{"data", "vpn"}; !reflect.DeepEqual(selection.Required, want) {
+		t.Errorf("required = %q, want %q", selection.Required, want)
+	}
+
+	_, err = SelectServices(project, FilterOptions{ExcludeServices: []string{"app"}, FailOnRequired: true})
+	if err == nil || !strings.Contains(err.Error(), "sidecar -> app (links)") {
+		t.Errorf("error = %v, want it to name the sidecar -> app link", err)
+	}
+}
+
+func TestRemoveDanglingDependencies(t *testing.T) {
+	project := testProject()
+	delete(project.Services, "db")
+	delete(project.Services, "cache")
+
+	dropped := RemoveDanglingDependencies(project)
+	if want := []string{"api -> cache", "api -> db", "worker -> db"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped = %q, want %q", dropped, want)
+	}
+	if dependsOn := project.Services["api"].DependsOn; dependsOn != nil {
+		t.Errorf("api depends_on = %v, want nil", dependsOn)
+	}
+	if _, exists := project.Services["web"].DependsOn["api"]; !exists {
+		t.Errorf("web no longer depends on api")
+	}
+
+	if dropped := RemoveDanglingDependencies(project); dropped != nil {
+		t.Errorf("second pass dropped %q, want nothing", dropped)
+	}
+}
+
+func TestMatchingPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"web", []string{"web", "api"}, []string{"web"}},
+		{"web-1", []string{"web-?", "web-*", "web"}, []string{"web-?", "web-*"}},
+		{"web-1", []string{"web-[0-9]"}, []string{"web-[0-9]"}},
+		{"web", []string{"[", "we"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := MatchingPatterns(tt.name, tt.patterns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MatchingPatterns(%q, %q) = %q, want %q", tt.name, tt.patterns, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package quay
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Logger receives a line for every port ApplyPortMappings publishes or unpublishes. It
+// discards them unless replaced
+var Logger = log.New(io.Discard, "", 0)
+
+// PortMapping represents a port mapping for a service
+type PortMapping struct {
+	ServiceName   string
+	HostIP        string
+	HostPort      string
+	ContainerPort string
+	Protocol      string
+}
+
+// ParsePortMapping parses a port mapping string in the format
+// service:[host_ip:]host_port:container_port[/protocol]. The host IP may be an IPv4 address or
+// an IPv6 address in brackets, and the host port may be omitted (service:container_port) to
+// stop publishing the container port. Ports may be START-END ranges of equal length, which are
+// expanded into one mapping per port
+func ParsePortMapping(mapping string) ([]PortMapping, error) {
+	formatErr := fmt.Errorf("invalid format, expected SERVICE:[[HOST_IP:]HOST_PORT:]CONTAINER_PORT[/PROTOCOL]")
+
+	spec, protocol, _ := strings.Cut(mapping, "/")
+	protocol = strings.ToLower(protocol)
+
+	serviceName, ports, found := strings.Cut(spec, ":")
+	if !found || serviceName == "" {
+		return nil, formatErr
+	}
+
+	// A bracketed IPv6 host IP contains colons itself, so take it off before splitting
+	hostIP := ""
+	if strings.HasPrefix(ports, "[") {
+		address, rest, found := strings.Cut(ports[1:], "]:")
+		if !found {
+			return nil, formatErr
+		}
+		hostIP, ports = address, rest
+		if !strings.Contains(ports, ":") {
+			return nil, formatErr
+		}
+	}
+
+	var hostPort, containerPort string
+	parts := strings.Split(ports, ":")
+	switch {
+	case len(parts) == 1:
+		containerPort = parts[0]
+	case len(parts) == 2:
+		hostPort, containerPort = parts[0], parts[1]
+	case len(parts) == 3 && hostIP == "":
+		hostIP, hostPort, containerPort = parts[0], parts[1], parts[2]
+	default:
+		return nil, formatErr
+	}
+
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	if protocol != "tcp" && protocol != "udp" {
+		return nil, fmt.Errorf("invalid protocol: %s, expected tcp or udp", protocol)
+	}
+
+	if hostIP != "" && net.ParseIP(hostIP) == nil {
+		return nil, fmt.Errorf("invalid host IP: %s", hostIP)
+	}
+
+	// Validate port numbers. Host port 0 lets Docker pick a free port
+	containerStart, containerEnd, err := ParsePortRange(containerPort, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid container port: %s, expected 1-65535 or a range", containerPort)
+	}
+
+	hostStart, hostEnd := uint64(0), uint64(0)
+	if hostPort != "" || hostIP != "" {
+		hostStart, hostEnd, err = ParsePortRange(hostPort, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host port: %s, expected 0-65535 or a range", hostPort)
+		}
+		if hostEnd-hostStart != containerEnd-containerStart {
+			return nil, fmt.Errorf("host port range %s and container port range %s differ in length", hostPort, containerPort)
+		}
+		if hostStart == 0 && hostEnd != hostStart {
+			return nil, fmt.Errorf("invalid host port: %s, port 0 cannot be part of a range", hostPort)
+		}
+	}
+
+	var mappings []PortMapping
+	for offset := uint64(0); offset <= containerEnd-containerStart; offset++ {
+		mappedHostPort := ""
+		if hostPort != "" {
+			mappedHostPort = strconv.FormatUint(hostStart+offset, 10)
+		}
+		mappings = append(mappings, PortMapping{
+			ServiceName:   serviceName,
+			HostIP:        hostIP,
+			HostPort:      mappedHostPort,
+			ContainerPort: strconv.FormatUint(containerStart+offset, 10),
+			Protocol:      protocol,
+		})
+	}
+
+	return mappings, nil
+}
+
+// ParsePortRange parses a port number or a START-END port range, where every port has to lie
+// between min and 65535
+func ParsePortRange(value string, min uint64) (uint64, uint64, error) {
+	startValue, endValue, isRange := strings.Cut(value, "-")
+	if !isRange {
+		endValue = startValue
+	}
+
+	start, err := strconv.ParseUint(startValue, 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.ParseUint(endValue, 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < min || end < start {
+		return 0, 0, fmt.Errorf("invalid port range %s", value)
+	}
+
+	return start, end, nil
+}
+
+// ApplyPortMappings modifies service port mappings in the project and returns a list of
+// services that were requested but not found. Services matching a noPorts name or glob pattern
+// lose all their published ports first, so explicit port mappings for them are the only ports
+// left. A mapping without a host port stops publishing its container port. Port slices are
+// copied before they are changed, so a project returned by FilterProject can be modified
+// without affecting the project it was filtered from
+func ApplyPortMappings(project *types.Project, portMappings []PortMapping, noPorts []string) ([]string, error) {
+	var missingServices []string
+
+	missingNoPorts := make(map[string]bool)
+	for _, pattern := range noPorts {
+		missingNoPorts[pattern] = true
+	}
+
+	for name, service := range project.Services {
+		matched := MatchingPatterns(name, noPorts)
+		if len(matched) == 0 {
+			continue
+		}
+		for _, pattern := range matched {
+			delete(missingNoPorts, pattern)
+		}
+
+		service.Ports = nil
+		project.Services[name] = service
+	}
+
+	for pattern := range missingNoPorts {
+		missingServices = append(missingServices, pattern)
+	}
+
+	for _, mapping := range portMappings {
+		service, exists := project.Services[mapping.ServiceName]
+		if !exists {
+			missingServices = append(missingServices, mapping.ServiceName)
+			continue
+		}
+
+		// ParsePortMapping rejects invalid container ports, so this only guards against
+		// mappings built elsewhere
+		containerPort, err := strconv.ParseUint(mapping.ContainerPort, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container port '%s' for %s", mapping.ContainerPort, mapping.ServiceName)
+		}
+		containerPortUint32 := uint32(containerPort)
+
+		// The ports slice is shared with the unfiltered project, so work on a copy
+		service.Ports = append([]types.ServicePortConfig(nil), service.Ports...)
+
+		// Without a host port the container port must no longer be published at all
+		if mapping.HostPort == "" {
+			Logger.Printf("Unpublishing port %s/%s of %s", mapping.ContainerPort, mapping.Protocol, mapping.ServiceName)
+			var ports []types.ServicePortConfig
+			for _, port := range service.Ports {
+				if port.Target != containerPortUint32 || PortProtocol(port) != mapping.Protocol {
+					ports = append(ports, port)
+				}
+			}
+			service.Ports = ports
+			project.Services[mapping.ServiceName] = service
+			continue
+		}
+
+		if mapping.HostIP != "" {
+			Logger.Printf("Publishing port %s/%s of %s on %s", mapping.ContainerPort, mapping.Protocol, mapping.ServiceName, net.JoinHostPort(mapping.HostIP, mapping.HostPort))
+		} else {
+			Logger.Printf("Publishing port %s/%s of %s on host port %s", mapping.ContainerPort, mapping.Protocol, mapping.ServiceName, mapping.HostPort)
+		}
+
+		// Create or update the ports configuration for the service
+		newPort := types.ServicePortConfig{
+			Mode:      "ingress",
+			HostIP:    mapping.HostIP,
+			Published: mapping.HostPort,
+			Target:    containerPortUint32,
+			Protocol:  mapping.Protocol,
+		}
+
+		// Check if there's an existing port mapping for the container port and protocol,
+		// so TCP and UDP mappings of the same port are handled independently
+		portUpdated := false
+		for i, port := range service.Ports {
+			if port.Target == containerPortUint32 && PortProtocol(port) == mapping.Protocol {
+				// Update the existing port mapping, keeping long syntax fields like mode and name
+				service.Ports[i].Published = mapping.HostPort
+				if mapping.HostIP != "" {
+					service.Ports[i].HostIP = mapping.HostIP
+				}
+				portUpdated = true
+				break
+			}
+		}
+
+		// If no existing mapping was found, add a new one
+		if !portUpdated {
+			service.Ports = append(service.Ports, newPort)
+		}
+
+		// Update the service in the project
+		project.Services[mapping.ServiceName] = service
+	}
+
+	return missingServices, nil
+}
+
+// PortProtocol returns the protocol of a port configuration, treating an unset protocol as tcp
+func PortProtocol(port types.ServicePortConfig) string {
+	if port.Protocol == "" {
+		return "tcp"
+	}
+	return port.Protocol
+}
@@ -0,0 +1,256 @@
+package quay
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    []PortMapping
+		wantErr string
+	}{
+		{
+			mapping: "web:8080:80",
+			want:    []PortMapping{{ServiceName: "web", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:80",
+			want:    []PortMapping{{ServiceName: "web", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "dns:5353:53/udp",
+			want:    []PortMapping{{ServiceName: "dns", HostPort: "5353", ContainerPort: "53", Protocol: "udp"}},
+		},
+		{
+			mapping: "dns:5353:53/UDP",
+			want:    []PortMapping{{ServiceName: "dns", HostPort: "5353", ContainerPort: "53", Protocol: "udp"}},
+		},
+		{
+			mapping: "web:8080:80/tcp",
+			want:    []PortMapping{{ServiceName: "web", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:8080:80/sctp",
+			wantErr: "invalid protocol: sctp",
+		},
+		{
+			mapping: "web:127.0.0.1:8080:80",
+			want:    []PortMapping{{ServiceName: "web", HostIP: "127.0.0.1", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:[::1]:8080:80",
+			want:    []PortMapping{{ServiceName: "web", HostIP: "::1", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:[2001:db8::1]:8080:80/udp",
+			want:    []PortMapping{{ServiceName: "web", HostIP: "2001:db8::1", HostPort: "8080", ContainerPort: "80", Protocol: "udp"}},
+		},
+		{
+			mapping: "web:[::1]:80",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: "web:[::1:8080:80",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: "web:::1:8080:80",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: "web:localhost:8080:80",
+			wantErr: "invalid host IP: localhost",
+		},
+		{
+			mapping: "web:0:80",
+			want:    []PortMapping{{ServiceName: "web", HostPort: "0", ContainerPort: "80", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:1:1",
+			want:    []PortMapping{{ServiceName: "web", HostPort: "1", ContainerPort: "1", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:65535:65535",
+			want:    []PortMapping{{ServiceName: "web", HostPort: "65535", ContainerPort: "65535", Protocol: "tcp"}},
+		},
+		{
+			mapping: "web:65536:80",
+			wantErr: "invalid host port: 65536",
+		},
+		{
+			mapping: "web:8080:65536",
+			wantErr: "invalid container port: 65536",
+		},
+		{
+			mapping: "web:8080:0",
+			wantErr: "invalid container port: 0",
+		},
+		{
+			mapping: "web:-1:80",
+			wantErr: "invalid host port: -1",
+		},
+		{
+			mapping: "web:8000-8002:9000-9002",
+			want: []PortMapping{
+				{ServiceName: "web", HostPort: "8000", ContainerPort: "9000", Protocol: "tcp"},
+				{ServiceName: "web", HostPort: "8001", ContainerPort: "9001", Protocol: "tcp"},
+				{ServiceName: "web", HostPort: "8002", ContainerPort: "9002", Protocol: "tcp"},
+			},
+		},
+		{
+			mapping: "web:[::1]:65534-65535:80-81/udp",
+			want: []PortMapping{
+				{ServiceName: "web", HostIP: "::1", HostPort: "65534", ContainerPort: "80", Protocol: "udp"},
+				{ServiceName: "web", HostIP: "::1", HostPort: "65535", ContainerPort: "81", Protocol: "udp"},
+			},
+		},
+		{
+			mapping: "web:9000-9001",
+			want: []PortMapping{
+				{ServiceName: "web", ContainerPort: "9000", Protocol: "tcp"},
+				{ServiceName: "web", ContainerPort: "9001", Protocol: "tcp"},
+			},
+		},
+		{
+			mapping: "web:8000-8002:9000-9001",
+			wantErr: "differ in length",
+		},
+		{
+			mapping: "web:0-1:80-81",
+			wantErr: "port 0 cannot be part of a range",
+		},
+		{
+			mapping: "web:8002-8000:9000-9002",
+			wantErr: "invalid host port: 8002-8000",
+		},
+		{
+			mapping: "web:65535-65536:80-81",
+			wantErr: "invalid host port: 65535-65536",
+		},
+		{
+			mapping: "web",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: ":8080:80",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: "web:127.0.0.1:8080:80:90",
+			wantErr: "invalid format",
+		},
+		{
+			mapping: "web:8080:http",
+			wantErr: "invalid container port: http",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mapping, func(t *testing.T) {
+			got, err := ParsePortMapping(tt.mapping)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		min       uint64
+		wantStart uint64
+		wantEnd   uint64
+		wantErr   bool
+	}{
+		{value: "0", min: 0, wantStart: 0, wantEnd: 0},
+		{value: "0", min: 1, wantErr: true},
+		{value: "1", min: 1, wantStart: 1, wantEnd: 1},
+		{value: "65535", min: 1, wantStart: 65535, wantEnd: 65535},
+		{value: "65536", min: 0, wantErr: true},
+		{value: "1-65535", min: 1, wantStart: 1, wantEnd: 65535},
+		{value: "80-80", min: 1, wantStart: 80, wantEnd: 80},
+		{value: "81-80", min: 1, wantErr: true},
+		{value: "80-", min: 1, wantErr: true},
+		{value: "-80", min: 0, wantErr: true},
+		{value: "", min: 0, wantErr: true},
+		{value: "eighty", min: 0, wantErr: true},
+		{value: "+80", min: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		start, end, err := ParsePortRange(tt.value, tt.min)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePortRange(%q, %d) = %d, %d, want an error", tt.value, tt.min, start, end)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePortRange(%q, %d) returned an error: %v", tt.value, tt.min, err)
+			continue
+		}
+		if start != tt.wantStart || end != tt.wantEnd {
+			t.Errorf("ParsePortRange(%q, %d) = %d, %d, want %d, %d", tt.value, tt.min, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestApplyPortMappings(t *testing.T) {
+	original := types.ServicePortConfig{Mode: "ingress", Name: "http", Published: "80", Target: 80, Protocol: "tcp"}
+	project := &types.Project{
+		Services: types.Services{
+			"web": {Name: "web", Ports: []types.ServicePortConfig{
+				original,
+				{Published: "53", Target: 53, Protocol: "udp"},
+			}},
+			"admin": {Name: "admin", Ports: []types.ServicePortConfig{{Published: "9000", Target: 9000}}},
+		},
+	}
+	filtered := *project
+	filtered.Services = types.Services{"web": project.Services["web"], "admin": project.Services["admin"]}
+
+	mappings := []PortMapping{
+		{ServiceName: "web", HostIP: "::1", HostPort: "8080", ContainerPort: "80", Protocol: "tcp"},
+		{ServiceName: "web", ContainerPort: "53", Protocol: "udp"},
+		{ServiceName: "web", HostPort: "8443", ContainerPort: "443", Protocol: "tcp"},
+		{ServiceName: "proxy", HostPort: "80", ContainerPort: "80", Protocol: "tcp"},
+	}
+	missing, err := ApplyPortMappings(&filtered, mappings, []string{"adm*", "nothing-*"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"nothing-*", "proxy"}; !reflect.DeepEqual(sorted(missing), want) {
+		t.Errorf("missing = %q, want %q", missing, want)
+	}
+
+	wantWeb := []types.ServicePortConfig{
+		{Mode: "ingress", Name: "http", HostIP: "::1", Published: "8080", Target: 80, Protocol: "tcp"},
+		{Mode: "ingress", Published: "8443", Target: 443, Protocol: "tcp"},
+	}
+	if got := filtered.Services["web"].Ports; !reflect.DeepEqual(got, wantWeb) {
+		t.Errorf("web ports = %+v, want %+v", got, wantWeb)
+	}
+	if got := filtered.Services["admin"].Ports; got != nil {
+		t.Errorf("admin ports = %+v, want none", got)
+	}
+
+	if got := project.Services["web"].Ports[0]; !reflect.DeepEqual(got, original) {
+		t.Errorf("the original project was modified: %+v", got)
+	}
+}
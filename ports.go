@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/yarlson/quay/pkg/quay"
+)
+
+// Values of the STATUS column of quay ports --live
+const (
+	portStatusOK         = "ok"
+	portStatusMismatch   = "mismatch"
+	portStatusNotRunning = "not running"
+)
+
+// portListing describes a published port for quay ports. Live and Status are only set with --live
+type portListing struct {
+	Service       string `json:"service"`
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      string `json:"host_port"`
+	ContainerPort uint32 `json:"container_port"`
+	Protocol      string `json:"protocol"`
+	Live          string `json:"live,omitempty"`
+	Status        string `json:"status,omitempty"`
+}
+
+// executePortsCommand handles "quay ports": it prints the ports the filtered project publishes
+// once all overrides are applied. --live also asks docker-compose where the ports of running
+// services are actually published and flags bindings that differ from the configuration
+func executePortsCommand(composePaths []string, cmdArgs commandArgs) error {
+	live := false
+	for _, option := range cmdArgs.cmdOptions {
+		switch option {
+		case "--live":
+			live = true
+		default:
+			return fmt.Errorf("unsupported ports option: %s", option)
+		}
+	}
+	if cmdArgs.format == formatYAML {
+		return fmt.Errorf("unsupported ports format: %s", cmdArgs.format)
+	}
+
+	project, err := loadFilteredProject(composePaths, cmdArgs)
+	if err != nil {
+		return err
+	}
+
+	listing := listPorts(project)
+
+	if live {
+		if err := addLiveBindings(project, listing); err != nil {
+			return err
+		}
+	}
+
+	if cmdArgs.format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listing)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "SERVICE\tHOST_IP\tHOST_PORT\tCONTAINER_PORT\tPROTOCOL"
+	if live {
+		header += "\tLIVE\tSTATUS"
+	}
+	fmt.Fprintln(writer, header)
+	for _, port := range listing {
+		row := fmt.Sprintf("%s\t%s\t%s\t%d\t%s", port.Service, port.HostIP, port.HostPort, port.ContainerPort, port.Protocol)
+		if live {
+			row += fmt.Sprintf("\t%s\t%s", port.Live, port.Status)
+		}
+		fmt.Fprintln(writer, row)
+	}
+	return writer.Flush()
+}
+
+// listPorts returns the published ports of all services in the project, sorted by service
+func listPorts(project *types.Project) []portListing {
+	listing := []portListing{}
+	for _, name := range project.ServiceNames() {
+		for _, port := range project.Services[name].Ports {
+			if port.Published == "" {
+				continue
+			}
+			listing = append(listing, portListing{
+				Service:       name,
+				HostIP:        port.HostIP,
+				HostPort:      port.Published,
+				ContainerPort: port.Target,
+				Protocol:      quay.PortProtocol(port),
+			})
+		}
+	}
+	return listing
+}
+
+// addLiveBindings looks up where each listed port is published by the running containers and
+// compares that to the configured host IP and port
+func addLiveBindings(project *types.Project, listing []portListing) error {
+	yamlData, err := marshalProject(project)
+	if err != nil {
+		return err
+	}
+
+	composeFile, err := writeTempProjectFile(yamlData)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(composeFile)
+
+	composeArgs := filteredProjectArgs(project, composeFile)
+	for i, port := range listing {
+		containerPort := strconv.FormatUint(uint64(port.ContainerPort), 10)
+		binding, err := queryPublishedPort(composeArgs, port.Service, containerPort, port.Protocol, io.Discard)
+		if err != nil || binding == "" || strings.HasSuffix(binding, ":0") {
+			listing[i].Status = portStatusNotRunning
+			continue
+		}
+
+		listing[i].Live = binding
+		listing[i].Status = portStatusMismatch
+		if bindingMatches(port, binding) {
+			listing[i].Status = portStatusOK
+		}
+	}
+	return nil
+}
+
+// bindingMatches reports whether a live "host_ip:host_port" binding agrees with the configured
+// port. Host port 0 lets Docker pick any port, and a range accepts any port within it
+func bindingMatches(port portListing, binding string) bool {
+	host, livePort, err := net.SplitHostPort(binding)
+	if err != nil {
+		return false
+	}
+
+	if port.HostIP != "" && net.ParseIP(port.HostIP).String() != net.ParseIP(host).String() {
+		return false
+	}
+
+	if port.HostPort == "0" {
+		return true
+	}
+
+	start, end, err := quay.ParsePortRange(port.HostPort, 0)
+	if err != nil {
+		return false
+	}
+	live, err := strconv.ParseUint(livePort, 10, 16)
+	return err == nil && live >= start && live <= end
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are the signals quay relays to docker-compose
+var forwardedSignals = []os.Signal{unix.SIGINT, unix.SIGTERM, unix.SIGHUP}
+
+// startInProcessGroup makes docker-compose start in a process group of its own, so it only gets
+// the signals quay forwards rather than each of them twice. When quay runs in the foreground of
+// a terminal, the new group takes the terminal over: interactive commands can still read from
+// it, and Ctrl-C goes to docker-compose directly. The returned function hands the terminal back
+// to quay once docker-compose has exited
+func startInProcessGroup(cmd *exec.Cmd) func() {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if cmd.Stdin != os.Stdin {
+		return func() {}
+	}
+	terminal := int(os.Stdin.Fd())
+	foreground, err := unix.IoctlGetInt(terminal, unix.TIOCGPGRP)
+	if err != nil || foreground != unix.Getpgrp() {
+		return func() {}
+	}
+
+	// Ctty is the terminal's descriptor in docker-compose, which is its standard input
+	cmd.SysProcAttr = &syscall.SysProcAttr{Foreground: true, Ctty: 0}
+	return func() {
+		// Taking the terminal back from a background process group raises SIGTTOU, which
+		// would stop quay
+		signal.Ignore(unix.SIGTTOU)
+		defer signal.Reset(unix.SIGTTOU)
+		_ = unix.IoctlSetPointerInt(terminal, unix.TIOCSPGRP, unix.Getpgrp())
+	}
+}
+
+// signalProcess sends a signal to docker-compose and the processes it started, such as the
+// Compose plugin run by the docker CLI
+func signalProcess(process *os.Process, sig os.Signal) error {
+	return unix.Kill(-process.Pid, sig.(syscall.Signal))
+}
+
+// killProcess kills docker-compose and the processes it started
+func killProcess(process *os.Process) error {
+	return unix.Kill(-process.Pid, unix.SIGKILL)
+}
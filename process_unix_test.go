@@ -0,0 +1,302 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stubbornCompose is a fake docker-compose that ignores SIGTERM, only noting it in $LOG, and
+// starts a child in its process group that exits on SIGTERM. It touches $READY once both
+// handlers are in place
+const stubbornCompose = `#!/bin/sh
+sh -c 'trap "echo child >> \"$LOG\"; exit 0" TERM; touch "$READY.child"; while :; do sleep 0.05; done' &
+trap 'echo compose >> "$LOG"' TERM
+while [ ! -e "$READY.child" ]; do sleep 0.05; done
+touch "$READY"
+while :; do sleep 0.05; done
+`
+
+func TestRunComposeCommandForwardsSignalsAndKills(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the fake docker-compose")
+	}
+
+	dir := t.TempDir()
+	composeBin := filepath.Join(dir, "docker-compose")
+	if err := os.WriteFile(composeBin, []byte(stubbornCompose), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "signals.log")
+	readyPath := filepath.Join(dir, "ready")
+
+	defer func(timeout time.Duration, writer io.Writer) {
+		signalKillTimeout, diagnostics = timeout, writer
+	}(signalKillTimeout, diagnostics)
+	signalKillTimeout = 500 * time.Millisecond
+	diagnostics = io.Discard
+
+	// Keep the SIGTERM sent below from terminating the test binary, whatever the timing
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, syscall.SIGTERM)
+	defer signal.Stop(received)
+
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(readyPath); err == nil {
+				_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				return
+			}
+		}
+	}()
+
+	cmd := exec.Command(composeBin)
+	cmd.Env = append(os.Environ(), "LOG="+logPath, "READY="+readyPath)
+	start := time.Now()
+	err := runComposeCommand(cmd)
+	elapsed := time.Since(start)
+
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != 128+int(syscall.SIGKILL) {
+		t.Fatalf("error = %v, want exit code %d", err, 128+int(syscall.SIGKILL))
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("docker-compose was killed after %s, want about %s", elapsed, signalKillTimeout)
+	}
+
+	// The child notes the signal right away, docker-compose once its sleep ends
+	var signaled []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		logData, _ := os.ReadFile(logPath)
+		if signaled = strings.Fields(string(logData)); len(signaled) == 2 {
+			break
+		}
+	}
+	if !contains(signaled, "compose") || !contains(signaled, "child") {
+		t.Errorf("signaled processes = %q, want both compose and its child", signaled)
+	}
+}
+
+// useFakeCompose runs script as the compose command for the rest of the test
+func useFakeCompose(t *testing.T, script string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the fake docker-compose")
+	}
+
+	composeBin := filepath.Join(t.TempDir(), "docker-compose")
+	if err := os.WriteFile(composeBin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	composeCommand.once.Do(func() {})
+	bin, prefix, err := composeCommand.bin, composeCommand.prefix, composeCommand.err
+	t.Cleanup(func() { composeCommand.bin, composeCommand.prefix, composeCommand.err = bin, prefix, err })
+	composeCommand.bin, composeCommand.prefix, composeCommand.err = composeBin, nil, nil
+}
+
+// pipeStdin makes input the standard input of quay for the rest of the test
+func pipeStdin(t *testing.T, input string) {
+	t.Helper()
+	stdinPath := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(stdinPath, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(stdinPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := os.Stdin
+	t.Cleanup(func() {
+		os.Stdin = original
+		stdin.Close()
+	})
+	os.Stdin = stdin
+}
+
+// runQuay runs quay with the given command line arguments
+func runQuay(t *testing.T, args ...string) error {
+	t.Helper()
+	original := os.Args
+	t.Cleanup(func() { os.Args = original })
+	os.Args = append([]string{"quay"}, args...)
+	return run()
+}
+
+// recordingCompose is a fake docker-compose that writes its arguments, one per line, to
+// $ARGS, copies the compose file following -f to $CONFIG and its standard input to $STDIN
+const recordingCompose = `#!/bin/sh
+: > "$ARGS"
+for arg in "$@"; do echo "$arg" >> "$ARGS"; done
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-f" ]; then cp "$2" "$CONFIG"; break; fi
+	shift
+done
+cat > "$STDIN"
+`
+
+// recordCompose runs recordingCompose as the compose command and returns the paths of the
+// files it records its arguments, compose file and standard input to
+func recordCompose(t *testing.T) (string, string, string) {
+	t.Helper()
+	useFakeCompose(t, recordingCompose)
+	dir := t.TempDir()
+	argsPath, configPath, stdinPath := filepath.Join(dir, "args"), filepath.Join(dir, "config"), filepath.Join(dir, "stdin")
+	t.Setenv("ARGS", argsPath)
+	t.Setenv("CONFIG", configPath)
+	t.Setenv("STDIN", stdinPath)
+	return argsPath, configPath, stdinPath
+}
+
+// readRecorded returns the content of a file written by recordingCompose
+func readRecorded(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("fake docker-compose didn't record %s: %v", filepath.Base(path), err)
+	}
+	return string(data)
+}
+
+func TestComposeCommandReadsStdin(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	composePath := writeComposeFile(t, t.TempDir(), "name: stdin\nservices:\n  web:\n    image: nginx\n  db:\n    image: postgres\n")
+
+	for _, args := range [][]string{
+		{"-f", composePath, "exec", "-T", "db", "psql"},
+		{"-f", composePath, "run", "--include", "db", "--rm", "-T", "db", "psql"},
+	} {
+		_, _, stdinPath := recordCompose(t)
+		pipeStdin(t, "SELECT 1;\n")
+
+		if err := runQuay(t, args...); err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+		if got := readRecorded(t, stdinPath); got != "SELECT 1;\n" {
+			t.Errorf("%q: docker-compose read %q from stdin, want the piped input", args, got)
+		}
+	}
+}
+
+func TestComposeFileFromStdin(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	argsPath, configPath, _ := recordCompose(t)
+	pipeStdin(t, "name: piped\nservices:\n  web:\n    image: nginx\n  db:\n    image: postgres\n")
+
+	if err := runQuay(t, "-f", "-", "up", "-d", "--include", "web"); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	args := strings.Fields(readRecorded(t, argsPath))
+	if len(args) < 2 || args[0] != "-f" || args[1] == stdinComposeFile {
+		t.Errorf("args = %q, want the compose file read from stdin passed as a file", args)
+	}
+	config := readRecorded(t, configPath)
+	if !strings.Contains(config, "name: piped") || !strings.Contains(config, "web:") || strings.Contains(config, "db:") {
+		t.Errorf("compose file = %q, want the piped project filtered to web", config)
+	}
+}
+
+func TestFilteredCommandFromAnotherDirectory(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	projectDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"web.env": "MODE=production\n", "secret.txt": "s3cret\n"} {
+		if err := os.WriteFile(filepath.Join(projectDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	composePath := writeComposeFile(t, projectDir, `name: elsewhere
+services:
+  web:
+    build: ./web
+    env_file: web.env
+    volumes:
+      - ./html:/usr/share/nginx/html:ro
+    secrets:
+      - token
+  db:
+    image: postgres
+secrets:
+  token:
+    file: ./secret.txt
+`)
+
+	argsPath, configPath, _ := recordCompose(t)
+	t.Chdir(t.TempDir())
+	if err := runQuay(t, "-f", composePath, "up", "-d", "--include", "web"); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	args := strings.Fields(readRecorded(t, argsPath))
+	if i := indexOf(args, "--project-directory"); i < 0 || i+1 >= len(args) || args[i+1] != projectDir {
+		t.Errorf("args = %q, want --project-directory %s", args, projectDir)
+	}
+
+	config := readRecorded(t, configPath)
+	for _, want := range []string{
+		filepath.Join(projectDir, "web"),
+		filepath.Join(projectDir, "web.env"),
+		filepath.Join(projectDir, "html"),
+		filepath.Join(projectDir, "secret.txt"),
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("compose file doesn't point at %s:\n%s", want, config)
+		}
+	}
+}
+
+// indexOf returns the index of value in values, or -1 when it's not there
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestComposeExitCodes(t *testing.T) {
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	composePath := writeComposeFile(t, t.TempDir(), "name: exits\nservices:\n  web:\n    image: nginx\n  db:\n    image: postgres\n")
+	useFakeCompose(t, "#!/bin/sh\nexit \"$CODE\"\n")
+
+	for _, code := range []int{0, 1, 2, 17, 130} {
+		t.Setenv("CODE", strconv.Itoa(code))
+		for _, args := range [][]string{
+			{"-f", composePath, "ps"},
+			{"-f", composePath, "up", "--include", "web"},
+		} {
+			err := runQuay(t, args...)
+			if code == 0 {
+				if err != nil {
+					t.Errorf("%q exiting with 0: unexpected error: %v", args, err)
+				}
+				continue
+			}
+			var exitErr *exitCodeError
+			if !errors.As(err, &exitErr) || exitErr.code != code {
+				t.Errorf("%q exiting with %d: error = %v, want exit code %d", args, code, err, code)
+			}
+		}
+	}
+
+	// quay's own errors are not mistaken for an exit status of docker-compose
+	err := runQuay(t, "-f", composePath, "up", "--include", "cache", "--strict")
+	var exitErr *exitCodeError
+	if err == nil || errors.As(err, &exitErr) {
+		t.Errorf("error = %v, want an error of quay itself", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// forwardedSignals are the signals quay relays to docker-compose
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// startInProcessGroup does nothing on Windows, where Ctrl-C reaches every process attached to
+// the console and there are no signals to forward otherwise
+func startInProcessGroup(cmd *exec.Cmd) func() {
+	return func() {}
+}
+
+// signalProcess sends a signal to docker-compose
+func signalProcess(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
+
+// killProcess kills docker-compose
+func killProcess(process *os.Process) error {
+	return process.Kill()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// httpHeaderEnv names an environment variable holding an extra "Name: value" header for
+// fetching remote compose files, typically carrying an auth token
+const httpHeaderEnv = "QUAY_HTTP_HEADER"
+
+// maxRemoteComposeFileSize is the largest compose file quay downloads
+const maxRemoteComposeFileSize = 10 << 20
+
+// stdinComposeFile is the compose path that reads the compose file from standard input
+const stdinComposeFile = "-"
+
+// remoteOptions configures how compose files given as URLs are fetched
+type remoteOptions struct {
+	headers []string
+	timeout time.Duration
+	// refresh downloads compose files again even when they are cached
+	refresh bool
+}
+
+// isRemoteComposeFile reports whether a compose file is given as an http or https URL
+func isRemoteComposeFile(composePath string) bool {
+	return strings.HasPrefix(composePath, "http://") || strings.HasPrefix(composePath, "https://")
+}
+
+// hasNoDirectory reports whether a compose file comes from a URL or standard input rather
+// than from a directory that could serve as project directory
+func hasNoDirectory(composePath string) bool {
+	return composePath == stdinComposeFile || isRemoteComposeFile(composePath)
+}
+
+// materializeComposeFiles turns compose files given as URLs into cached local files, and
+// writes a compose file given as "-" for standard input to a temporary file. It returns the
+// compose paths with those files in their place, along with a function that removes the
+// temporary files again. JSON compose files, as told by fileFormat, their name or, for
+// standard input and URLs, their content, are rewritten to temporary files compose-go reads correctly. Other local paths
+// are returned unchanged
+func materializeComposeFiles(composePaths []string, options remoteOptions, fileFormat string) ([]string, func(), error) {
+	var tempFiles []string
+	cleanup := func() {
+		for _, tempFile := range tempFiles {
+			os.Remove(tempFile)
+		}
+	}
+
+	localPaths := make([]string, len(composePaths))
+	for i, composePath := range composePaths {
+		switch {
+		case isRemoteComposeFile(composePath):
+			cachePath, err := cachedComposeFile(composePath, options)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			localPaths[i] = cachePath
+		case composePath == stdinComposeFile:
+			// Reading from a terminal would wait for input that never comes
+			if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				cleanup()
+				return nil, nil, fmt.Errorf("reading compose file from stdin: stdin is a terminal, pipe the compose file in")
+			}
+
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("reading compose file from stdin: %w", err)
+			}
+
+			tempPath, err := writeTempProjectFile(data)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			tempFiles = append(tempFiles, tempPath)
+			localPaths[i] = tempPath
+		default:
+			localPaths[i] = composePath
+		}
+
+		if fileFormat == formatYAML {
+			continue
+		}
+		data, err := os.ReadFile(localPaths[i])
+		if err != nil {
+			// Missing files are left to compose-go to report
+			continue
+		}
+		// Content is only sniffed for files without a name of their own, so a rewritten local
+		// file always has its project directory set from the original
+		explicit := isJSONComposeFile(composePath, fileFormat)
+		if !explicit && (!hasNoDirectory(composePath) || !looksLikeJSON(data)) {
+			continue
+		}
+		normalized, err := normalizeJSONComposeFile(data)
+		if err != nil {
+			if !explicit {
+				// Only started like JSON, such as a YAML flow mapping
+				continue
+			}
+			cleanup()
+			return nil, nil, fmt.Errorf("parsing JSON compose file %s: %w", composePath, err)
+		}
+		tempPath, err := writeTempProjectFile(normalized)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		tempFiles = append(tempFiles, tempPath)
+		localPaths[i] = tempPath
+	}
+
+	return localPaths, cleanup, nil
+}
+
+// cachedComposeFile returns the path of the cached copy of a remote compose file, which lives
+// in the user cache directory under a name derived from the URL. The file is downloaded when
+// it is not cached yet or when a refresh is requested
+func cachedComposeFile(url string, options remoteOptions) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("locating cache directory: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(url))
+	cachePath := filepath.Join(cacheDir, "quay", hex.EncodeToString(hash[:])+".yml")
+
+	if _, err := os.Stat(cachePath); err == nil && !options.refresh {
+		return cachePath, nil
+	}
+
+	headers := options.headers
+	if value := os.Getenv(httpHeaderEnv); value != "" {
+		headers = append(headers, value)
+	}
+
+	data, err := fetchComposeFile(&http.Client{Timeout: options.timeout}, url, headers)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return "", fmt.Errorf("creating cache directory: %w", err)
+	}
+	if err := os.WriteFile(cachePath, data, 0o600); err != nil {
+		return "", fmt.Errorf("caching compose file: %w", err)
+	}
+
+	return cachePath, nil
+}
+
+// fetchComposeFile downloads a compose file, sending the given "Name: value" headers
+func fetchComposeFile(client *http.Client, url string, headers []string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching compose file %s: %w", url, err)
+	}
+
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid HTTP header: expected NAME: VALUE")
+		}
+		request.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("fetching compose file: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching compose file %s: %s", url, response.Status)
+	}
+
+	// Read one byte more than allowed to tell a file of the maximum size from a larger one
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxRemoteComposeFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading compose file %s: %w", url, err)
+	}
+	if len(data) > maxRemoteComposeFileSize {
+		return nil, fmt.Errorf("fetching compose file %s: larger than %d MiB", url, maxRemoteComposeFileSize>>20)
+	}
+	return data, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTempCacheDir points the user cache directory at a new directory for the rest of the test
+func useTempCacheDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+}
+
+func TestCachedComposeFile(t *testing.T) {
+	useTempCacheDir(t)
+	t.Setenv(httpHeaderEnv, "X-Team: platform")
+
+	var requests atomic.Int32
+	version := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Team") != "platform" {
+			http.Error(w, "missing headers", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("services:\n  web:\n    image: nginx:" + version + "\n"))
+	}))
+	defer server.Close()
+
+	options := remoteOptions{headers: []string{"Authorization: Bearer token"}, timeout: 5 * time.Second}
+	url := server.URL + "/compose.yaml"
+
+	fetch := func(options remoteOptions) string {
+		t.Helper()
+		cachePath, err := cachedComposeFile(url, options)
+		if err != nil {
+			t.Fatalf("cachedComposeFile: %v", err)
+		}
+		data, err := os.ReadFile(cachePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := fetch(options); !strings.Contains(got, "nginx:1") {
+		t.Errorf("compose file = %q, want the served one", got)
+	}
+
+	version = "2"
+	if got := fetch(options); !strings.Contains(got, "nginx:1") || requests.Load() != 1 {
+		t.Errorf("compose file = %q after %d requests, want the cached copy", got, requests.Load())
+	}
+
+	options.refresh = true
+	if got := fetch(options); !strings.Contains(got, "nginx:2") || requests.Load() != 2 {
+		t.Errorf("compose file = %q after %d requests, want it downloaded again", got, requests.Load())
+	}
+
+	if _, err := cachedComposeFile(server.URL+"/other.yaml", remoteOptions{timeout: 5 * time.Second}); err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("error = %v, want the status of the failed request", err)
+	}
+}
+
+func TestFetchComposeFileSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := maxRemoteComposeFileSize
+		if r.URL.Path == "/large.yaml" {
+			size++
+		}
+		_, _ = w.Write([]byte(strings.Repeat("#", size)))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	data, err := fetchComposeFile(client, server.URL+"/max.yaml", nil)
+	if err != nil || len(data) != maxRemoteComposeFileSize {
+		t.Errorf("fetching a file of the maximum size: got %d bytes, %v", len(data), err)
+	}
+
+	_, err = fetchComposeFile(client, server.URL+"/large.yaml", nil)
+	if err == nil || !strings.Contains(err.Error(), "larger than 10 MiB") {
+		t.Errorf("error = %v, want the file rejected as too large", err)
+	}
+
+	_, err = fetchComposeFile(client, server.URL+"/max.yaml", []string{"Authorization"})
+	if err == nil || !strings.Contains(err.Error(), "invalid HTTP header") {
+		t.Errorf("error = %v, want an invalid header error", err)
+	}
+}
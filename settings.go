@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/yarlson/quay/pkg/quay"
+	"gopkg.in/yaml.v3"
+)
+
+// settingsFileName is the project settings file quay reads from the current directory or,
+// like compose files, from a parent directory
+const settingsFileName = ".quay.yml"
+
+// projectSettings holds the defaults read from a .quay.yml file. Options given on the command
+// line take precedence over them
+type projectSettings struct {
+	// path is where the settings were read from, empty when there is no settings file
+	path string
+	// File lists the compose files used when none are given with -f, COMPOSE_FILE or
+	// QUAY_COMPOSE_FILE, relative to the settings file. A single file may be given as a string
+	File stringList `yaml:"file"`
+	// Include and Exclude select services when neither the command line nor QUAY_INCLUDE and
+	// QUAY_EXCLUDE do
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// AutoRemoveOrphans is the default of -auto-remove-orphans when QUAY_AUTO_REMOVE_ORPHANS is unset
+	AutoRemoveOrphans *bool `yaml:"auto_remove_orphans"`
+	// Groups maps group names to the services selected by --group, given as names or glob patterns
+	Groups map[string][]string `yaml:"groups"`
+	// Ports are port mappings in the format of --port, applied before those given with --port
+	Ports []string `yaml:"ports"`
+	// Profiles are activated when neither --profile nor COMPOSE_PROFILES is given
+	Profiles []string `yaml:"profiles"`
+
+	portMappings []quay.PortMapping
+}
+
+// stringList is a list of strings that may also be written as a single string
+type stringList []string
+
+// UnmarshalYAML accepts either a scalar or a sequence of scalars
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// findSettingsFile looks for a settings file in the current directory and, unless searchParents
+// is false, in its parents up to the root of the git checkout
+func findSettingsFile(searchParents bool) (string, bool, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false, fmt.Errorf("getting working directory: %w", err)
+	}
+
+	for {
+		settingsPath := filepath.Join(dir, settingsFileName)
+		if _, err := os.Stat(settingsPath); err == nil {
+			return settingsPath, true, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if !searchParents || isProjectBoundary(dir) || parent == dir {
+			return "", false, nil
+		}
+		dir = parent
+	}
+}
+
+// loadProjectSettings reads and validates the settings file, if there is one. Environment
+// variables in values are expanded, and unknown keys are rejected so typos don't go unnoticed
+func loadProjectSettings(searchParents bool) (projectSettings, error) {
+	settingsPath, found, err := findSettingsFile(searchParents)
+	if err != nil || !found {
+		return projectSettings{}, err
+	}
+
+	data, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return projectSettings{}, fmt.Errorf("reading settings file: %w", err)
+	}
+
+	var settings projectSettings
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&settings); err != nil && !errors.Is(err, io.EOF) {
+		return projectSettings{}, fmt.Errorf("parsing %s: %w", settingsPath, err)
+	}
+	settings.path = settingsPath
+
+	for i, file := range settings.File {
+		file = os.ExpandEnv(file)
+		if file == "" {
+			return projectSettings{}, fmt.Errorf("parsing %s: empty compose file", settingsPath)
+		}
+		if !filepath.IsAbs(file) && !isRemoteComposeFile(file) {
+			file = filepath.Join(filepath.Dir(settingsPath), file)
+		}
+		settings.File[i] = file
+	}
+
+	if len(settings.Include) > 0 && len(settings.Exclude) > 0 {
+		return projectSettings{}, fmt.Errorf("parsing %s: cannot use both include and exclude", settingsPath)
+	}
+	for i, service := range settings.Include {
+		settings.Include[i] = os.ExpandEnv(service)
+	}
+	for i, service := range settings.Exclude {
+		settings.Exclude[i] = os.ExpandEnv(service)
+	}
+
+	for group, services := range settings.Groups {
+		if group == "" || len(services) == 0 {
+			return projectSettings{}, fmt.Errorf("parsing %s: group '%s' has no services", settingsPath, group)
+		}
+		for i, service := range services {
+			services[i] = os.ExpandEnv(service)
+		}
+	}
+
+	for i, profile := range settings.Profiles {
+		settings.Profiles[i] = os.ExpandEnv(profile)
+	}
+
+	for _, port := range settings.Ports {
+		mappings, err := quay.ParsePortMapping(os.ExpandEnv(port))
+		if err != nil {
+			return projectSettings{}, fmt.Errorf("parsing %s: invalid port mapping '%s': %w", settingsPath, port, err)
+		}
+		settings.portMappings = append(settings.portMappings, mappings...)
+	}
+
+	return settings, nil
+}
+
+// groupServices returns the services of the given groups, failing on groups that are not defined
+func (s projectSettings) groupServices(groups []string) (map[string][]string, error) {
+	selected := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		services, exists := s.Groups[group]
+		if !exists {
+			if s.path == "" {
+				return nil, fmt.Errorf("unknown group '%s': no %s found", group, settingsFileName)
+			}
+			return nil, fmt.Errorf("unknown group '%s' (defined groups: %s)", group, strings.Join(s.groupNames(), ", "))
+		}
+		selected[group] = services
+	}
+	return selected, nil
+}
+
+// groupNames returns the names of the defined groups, sorted
+func (s projectSettings) groupNames() []string {
+	names := make([]string, 0, len(s.Groups))
+	for name := range s.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkGroupServices fails when a selected group lists a service, or a pattern matching no
+// service, that is not defined in the project. Services disabled by their profiles count as defined
+func checkGroupServices(project *types.Project, groupServices map[string][]string) error {
+	var names []string
+	for name := range project.Services {
+		names = append(names, name)
+	}
+	for name := range project.DisabledServices {
+		names = append(names, name)
+	}
+
+	groups := make([]string, 0, len(groupServices))
+	for group := range groupServices {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		for _, service := range groupServices[group] {
+			found := false
+			for _, name := range names {
+				if len(quay.MatchingPatterns(name, []string{service})) > 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("group '%s' references unknown service '%s'", group, service)
+			}
+		}
+	}
+	return nil
+}
+
+// executeGroupsCommand handles "quay groups": it prints the groups defined in the settings
+// file along with their services, one group per line and sorted by name
+func executeGroupsCommand(settings projectSettings, cmdArgs commandArgs) error {
+	if len(cmdArgs.cmdOptions) > 0 {
+		return fmt.Errorf("unsupported groups option: %s", cmdArgs.cmdOptions[0])
+	}
+
+	if settings.path == "" {
+		return fmt.Errorf("no %s found", settingsFileName)
+	}
+
+	for _, name := range settings.groupNames() {
+		fmt.Printf("%s: %s\n", name, strings.Join(settings.Groups[name], ", "))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// writeSettingsFile writes a .quay.yml file to a new directory and makes it the working directory
+func writeSettingsFile(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, settingsFileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	return dir
+}
+
+func TestLoadProjectSettings(t *testing.T) {
+	t.Setenv("QUAY_TEST_ENV", "staging")
+	t.Setenv("QUAY_TEST_SERVICE", "api")
+	dir := writeSettingsFile(t, `file: compose.${QUAY_TEST_ENV}.yaml
+include: [web, "${QUAY_TEST_SERVICE}"]
+auto_remove_orphans: false
+groups:
+  backend: ["${QUAY_TEST_SERVICE}", "worker-*"]
+profiles: ["${QUAY_TEST_ENV}"]
+ports: ["web:8081:80"]
+`)
+
+	settings, err := loadProjectSettings(false)
+	if err != nil {
+		t.Fatalf("loadProjectSettings: %v", err)
+	}
+
+	if want := filepath.Join(dir, settingsFileName); settings.path != want {
+		t.Errorf("path = %q, want %q", settings.path, want)
+	}
+	if want := (stringList{filepath.Join(dir, "compose.staging.yaml")}); !reflect.DeepEqual(settings.File, want) {
+		t.Errorf("file = %q, want %q", settings.File, want)
+	}
+	if want := []string{"web", "api"}; !reflect.DeepEqual(settings.Include, want) {
+		t.Errorf("include = %q, want %q", settings.Include, want)
+	}
+	if settings.AutoRemoveOrphans == nil || *settings.AutoRemoveOrphans {
+		t.Errorf("auto_remove_orphans = %v, want false", settings.AutoRemoveOrphans)
+	}
+	if want := map[string][]string{"backend": {"api", "worker-*"}}; !reflect.DeepEqual(settings.Groups, want) {
+		t.Errorf("groups = %q, want %q", settings.Groups, want)
+	}
+	if want := []string{"staging"}; !reflect.DeepEqual(settings.Profiles, want) {
+		t.Errorf("profiles = %q, want %q", settings.Profiles, want)
+	}
+	if len(settings.portMappings) != 1 || settings.portMappings[0].ServiceName != "web" {
+		t.Errorf("port mappings = %+v, want one for web", settings.portMappings)
+	}
+}
+
+func TestLoadProjectSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "unknown key", content: "includes: [web]\n", want: "field includes not found"},
+		{name: "misspelled key", content: "auto_remove_orphan: true\n", want: "field auto_remove_orphan not found"},
+		{name: "include and exclude", content: "include: [web]\nexclude: [db]\n", want: "cannot use both include and exclude"},
+		{name: "empty group", content: "groups:\n  backend: []\n", want: "group 'backend' has no services"},
+		{name: "empty file", content: "file: ${QUAY_TEST_UNSET}\n", want: "empty compose file"},
+		{name: "invalid port", content: "ports: [web]\n", want: "invalid port mapping 'web'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeSettingsFile(t, tt.content)
+			_, err := loadProjectSettings(false)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProjectSettingsWithoutFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	settings, err := loadProjectSettings(false)
+	if err != nil {
+		t.Fatalf("loadProjectSettings: %v", err)
+	}
+	if !reflect.DeepEqual(settings, projectSettings{}) {
+		t.Errorf("settings = %+v, want none", settings)
+	}
+}
+
+func TestCheckGroupServices(t *testing.T) {
+	project := &types.Project{
+		Services: types.Services{
+			"web":      {Name: "web"},
+			"api":      {Name: "api"},
+			"worker-1": {Name: "worker-1"},
+		},
+		DisabledServices: types.Services{"pgadmin": {Name: "pgadmin", Profiles: []string{"debug"}}},
+	}
+
+	tests := []struct {
+		groups map[string][]string
+		want   string
+	}{
+		{groups: map[string][]string{"backend": {"api", "worker-*"}}},
+		{groups: map[string][]string{"debug": {"pgadmin"}}},
+		{groups: map[string][]string{"backend": {"api", "cache"}}, want: "group 'backend' references unknown service 'cache'"},
+		{groups: map[string][]string{"backend": {"db-*"}}, want: "group 'backend' references unknown service 'db-*'"},
+		{
+			groups: map[string][]string{"zeta": {"missing"}, "alpha": {"web", "unknown"}},
+			want:   "group 'alpha' references unknown service 'unknown'",
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkGroupServices(project, tt.groups)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("checkGroupServices(%v): unexpected error: %v", tt.groups, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("checkGroupServices(%v) error = %v, want %q", tt.groups, err, tt.want)
+		}
+	}
+}